@@ -18,12 +18,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -45,33 +47,33 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(appsource.AddToScheme(scheme))
+	// ArgoCD Applications and Projects, managed directly by the kubernetes backend
+	utilruntime.Must(argocd.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
+	config, err := controllers.LoadControllerConfig(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		// Logger is not set up yet
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "02ff6e16.io",
+		Scheme:                  scheme,
+		MetricsBindAddress:      config.MetricsBindAddress,
+		Port:                    9443,
+		HealthProbeBindAddress:  config.HealthProbeBindAddress,
+		LeaderElection:          config.LeaderElection,
+		LeaderElectionID:        config.LeaderElectionID,
+		LeaderElectionNamespace: config.LeaderElectionNamespace,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -80,17 +82,31 @@ func main() {
 
 	//AppSourceReconciler Initialization
 
+	argocdClients := &controllers.ClientManager{}
+	defer argocdClients.Close()
+
 	reconciler := controllers.AppSourceReconciler{
-		Client:      mgr.GetClient(),
-		Scheme:      mgr.GetScheme(),
-		ArgocdNS:    appsource.ArgocdNamespace,
-		ClusterHost: appsource.ClusterServerName,
+		ArgoCD:                argocdClients,
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		ArgocdNS:              config.ArgocdNamespace,
+		ClusterHost:           config.ClusterHost,
+		ConfigMap:             config.ConfigMap(),
+		StatusRefreshInterval: config.StatusRefreshInterval.Duration,
+		DeletionPollInterval:  config.DeletionPollInterval.Duration,
+		DeletionTimeout:       config.DeletionTimeout.Duration,
 	}
 
 	if err = (&reconciler).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AppSource")
 		os.Exit(1)
 	}
+	if config.EnableWebhooks {
+		if err = (&reconciler).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AppSource")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -101,6 +117,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("argocd", argocdClients.ReadyzCheck); err != nil {
+		setupLog.Error(err, "unable to set up argocd ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
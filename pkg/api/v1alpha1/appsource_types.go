@@ -29,12 +29,32 @@ const (
 	ArgocdNamespace = "argocd"
 )
 
+const (
+	// ManagedByLabel is set on the ArgoCD resources created by the AppSource controller
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the ManagedByLabel value of resources created by the AppSource controller
+	ManagedByValue = "argocd-appsource"
+	// OwnerNamespaceAnnotation records the namespace of the AppSource owning an ArgoCD Application
+	OwnerNamespaceAnnotation = "appsource.argoproj.io/owner-namespace"
+	// OwnerNameAnnotation records the name of the AppSource owning an ArgoCD Application
+	OwnerNameAnnotation = "appsource.argoproj.io/owner-name"
+	// OwnerUIDAnnotation records the UID of the AppSource owning an ArgoCD Application
+	OwnerUIDAnnotation = "appsource.argoproj.io/owner-uid"
+	// AdoptAnnotation set to "true" on an ArgoCD resource not created by the AppSource controller allows the controller to adopt it
+	AdoptAnnotation = "appsource.argoproj.io/adopt"
+	// ReleaseFinalizerAnnotation set to "true" on an AppSource releases its finalizer once a cascade-foreground deletion timed out,
+	// leaving the ArgoCD Application behind
+	ReleaseFinalizerAnnotation = "appsource.argoproj.io/release-finalizer"
+)
+
 type AppConditionMessage = string
 
 const (
 	ApplicationExistsMsg   AppConditionMessage = "ArgoCD Application exists"
 	ApplicationDeletionMsg AppConditionMessage = "ArgoCD Application was succesfully deleted"
 	ApplicationCreationMsg AppConditionMessage = "ArgoCD Application was successfully created"
+	ApplicationUpdateMsg   AppConditionMessage = "ArgoCD Application was successfully updated"
+	ApplicationDeletingMsg AppConditionMessage = "Waiting for ArgoCD Application resources to be deleted"
 )
 
 type AppSourceConditionType = string
@@ -44,16 +64,39 @@ const (
 	ApplicationCreationError AppSourceConditionType = "ApplicationCreationError"
 	// ApplicationCreationSuccess indicates that the controller was able to create the ArgoCD Application
 	ApplicationCreationSuccess AppSourceConditionType = "ApplicationCreationSuccess"
+	// ApplicationUpdateError indicates that the controller failed to update the ArgoCD Application
+	ApplicationUpdateError AppSourceConditionType = "ApplicationUpdateError"
+	// ApplicationUpdateSuccess indicates that the controller was able to update the ArgoCD Application
+	ApplicationUpdateSuccess AppSourceConditionType = "ApplicationUpdateSuccess"
 	// ApplicationDeletionError indicates that controller failed to delete application
 	ApplicationDeletionError AppSourceConditionType = "ApplicationDeletionError"
+	// ApplicationDeletionInProgress indicates that the controller is waiting for the ArgoCD Application resources to be deleted
+	ApplicationDeletionInProgress AppSourceConditionType = "ApplicationDeletionInProgress"
 	// // ApplicationDeletionSuccess indicates that the controller was able to delete the ArgoCD Application
 	// ApplicationDeletionSuccess AppSourceConditionType = "ApplicationDeletionSuccess"
+	// ApplicationConflict indicates that an ArgoCD Application with the AppSource name exists but is owned by someone else
+	ApplicationConflict AppSourceConditionType = "ApplicationConflict"
+	// ProjectConflict indicates that an ArgoCD AppProject with the profile project name exists but was not created by the controller
+	ProjectConflict AppSourceConditionType = "ProjectConflict"
+	// SyncPolicyClamped indicates that sync policy settings requested by the AppSource were not allowed by its profile and were dropped
+	SyncPolicyClamped AppSourceConditionType = "SyncPolicyClamped"
 	// ApplicationInvalidSpecError indicates that application source is invalid
 	ApplicationInvalidSpecError AppSourceConditionType = "InvalidSpecError"
 	// ApplicationUnknownError indicates an unknown controller error
 	ApplicationUnknownError AppSourceConditionType = "UnknownError"
 )
 
+type DeletionMode = string
+
+const (
+	// DeletionModeOrphan deletes the ArgoCD Application and leaves its resources in the cluster
+	DeletionModeOrphan DeletionMode = "orphan"
+	// DeletionModeCascadeForeground deletes the ArgoCD Application resources before the Application
+	DeletionModeCascadeForeground DeletionMode = "cascade-foreground"
+	// DeletionModeCascadeBackground deletes the ArgoCD Application and lets its resources be deleted in the background
+	DeletionModeCascadeBackground DeletionMode = "cascade-background"
+)
+
 type ConditionStatus = string
 
 const (
@@ -61,6 +104,19 @@ const (
 	ConditionFalse = "False"
 )
 
+// AppSourceSpec defines the desired state of AppSource
+type AppSourceSpec struct {
+	argocd.ApplicationSource `json:",inline"`
+	// SyncPolicy requests sync settings for the ArgoCD Application, settings not allowed
+	// by the project profile are rejected or clamped
+	SyncPolicy *argocd.SyncPolicy `json:"syncPolicy,omitempty"`
+	// DeletionMode selects how the ArgoCD Application is deleted along with the AppSource,
+	// defaults to the project profile deletionMode, or orphan
+	// +kubebuilder:validation:Enum=orphan;cascade-foreground;cascade-background
+	// +optional
+	DeletionMode DeletionMode `json:"deletionMode,omitempty"`
+}
+
 // AppSourceCondition holds the latest information about the AppSource conditions
 type AppSourceCondition struct {
 	// Type is an application condition type
@@ -79,18 +135,67 @@ type AppSourceStatus struct {
 	//TODO Rename to Conditions
 	//TODO Iterate through conditions and upsert the condition
 	Conditions []AppSourceCondition `json:"conditions,omitempty"`
+	// ApplicationName is the name of the ArgoCD Application created for the AppSource
+	ApplicationName string `json:"applicationName,omitempty"`
+	// ArgocdInstance is the name of the ArgoCD instance holding the Application
+	ArgocdInstance string `json:"argocdInstance,omitempty"`
+	// Profile explains which project profile the AppSource namespace matched
+	Profile *AppSourceProfileMatch `json:"profile,omitempty"`
+	// Sync is the sync status of the ArgoCD Application
+	Sync string `json:"sync,omitempty"`
+	// Health is the health status of the ArgoCD Application
+	Health string `json:"health,omitempty"`
+	// Revision is the revision the ArgoCD Application was last compared against
+	Revision string `json:"revision,omitempty"`
+	// ReconciledAt is the time the ArgoCD Application was last reconciled by ArgoCD
+	ReconciledAt *metav1.Time `json:"reconciledAt,omitempty"`
+	// Resources summarizes the sync and health status of the resources managed by the ArgoCD Application
+	Resources *AppSourceResourceSummary `json:"resources,omitempty"`
+}
+
+// AppSourceProfileMatch records the project profile matched by the AppSource namespace
+type AppSourceProfileMatch struct {
+	// Name of the matched profile
+	Name string `json:"name"`
+	// Groups captured by the profile namePattern, by name and by index
+	Groups map[string]string `json:"groups,omitempty"`
+	// Project is the ArgoCD project name resolved from the profile, empty if it could not be resolved
+	Project string `json:"project,omitempty"`
+}
+
+// AppSourceResourceSummary holds resource counts of the ArgoCD Application grouped by status
+type AppSourceResourceSummary struct {
+	// Total is the number of resources managed by the ArgoCD Application
+	Total int `json:"total"`
+	// OutOfSync is the number of resources which differ from the desired state
+	OutOfSync int `json:"outOfSync"`
+	// Healthy is the number of healthy resources
+	Healthy int `json:"healthy"`
+	// Progressing is the number of resources which are not yet healthy but may become healthy
+	Progressing int `json:"progressing"`
+	// Degraded is the number of resources which failed or are unable to become healthy
+	Degraded int `json:"degraded"`
+	// Missing is the number of resources which are not present in the cluster
+	Missing int `json:"missing"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Sync",type=string,JSONPath=`.status.sync`
+//+kubebuilder:printcolumn:name="Health",type=string,JSONPath=`.status.health`
+//+kubebuilder:printcolumn:name="Application",type=string,JSONPath=`.status.applicationName`,priority=10
+//+kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.status.argocdInstance`,priority=10
+//+kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.status.profile.name`,priority=10
+//+kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.revision`,priority=10
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // AppSource is the Schema for the appsources API
 type AppSource struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   argocd.ApplicationSource `json:"spec,omitempty"`
-	Status AppSourceStatus          `json:"status,omitempty"`
+	Spec   AppSourceSpec   `json:"spec,omitempty"`
+	Status AppSourceStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -124,7 +229,7 @@ func IsEqual(a, b []AppSourceCondition) bool {
 	if len(a) != len(b) {
 		return false
 	}
-	for i, _ := range a {
+	for i := range a {
 		if !ConditionIsEqual(a[i], b[i]) {
 			return false
 		}
@@ -132,16 +237,32 @@ func IsEqual(a, b []AppSourceCondition) bool {
 	return true
 }
 
-func (a *AppSource) UpsertConditions(newCondition AppSourceCondition) {
-	for i, _ := range a.Status.Conditions {
+// UpsertConditions inserts or updates the condition of the same type, returns false if it was already present unchanged
+func (a *AppSource) UpsertConditions(newCondition AppSourceCondition) bool {
+	for i := range a.Status.Conditions {
 		if a.Status.Conditions[i].Type == newCondition.Type {
+			if a.Status.Conditions[i].Status == newCondition.Status && a.Status.Conditions[i].Message == newCondition.Message {
+				// Condition has not changed, keep the original observation time
+				return false
+			}
 			// Update condition
 			a.Status.Conditions[i] = newCondition
-			return
+			return true
 		}
 	}
 	// Condition not found, insert it
 	a.Status.Conditions = append(a.Status.Conditions, newCondition)
+	return true
+}
+
+// RemoveCondition removes the condition of the given type, if present
+func (a *AppSource) RemoveCondition(conditionType AppSourceConditionType) {
+	for i := range a.Status.Conditions {
+		if a.Status.Conditions[i].Type == conditionType {
+			a.Status.Conditions = append(a.Status.Conditions[:i], a.Status.Conditions[i+1:]...)
+			return
+		}
+	}
 }
 
 func init() {
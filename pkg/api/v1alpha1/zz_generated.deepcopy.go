@@ -21,6 +21,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	applicationv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -30,7 +31,7 @@ func (in *AppSource) DeepCopyInto(out *AppSource) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSource.
@@ -51,6 +52,22 @@ func (in *AppSource) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppSourceCondition) DeepCopyInto(out *AppSourceCondition) {
+	*out = *in
+	in.ObservedAt.DeepCopyInto(&out.ObservedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSourceCondition.
+func (in *AppSourceCondition) DeepCopy() *AppSourceCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(AppSourceCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppSourceList) DeepCopyInto(out *AppSourceList) {
 	*out = *in
@@ -83,9 +100,88 @@ func (in *AppSourceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppSourceProfileMatch) DeepCopyInto(out *AppSourceProfileMatch) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSourceProfileMatch.
+func (in *AppSourceProfileMatch) DeepCopy() *AppSourceProfileMatch {
+	if in == nil {
+		return nil
+	}
+	out := new(AppSourceProfileMatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppSourceResourceSummary) DeepCopyInto(out *AppSourceResourceSummary) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSourceResourceSummary.
+func (in *AppSourceResourceSummary) DeepCopy() *AppSourceResourceSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(AppSourceResourceSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppSourceSpec) DeepCopyInto(out *AppSourceSpec) {
+	*out = *in
+	in.ApplicationSource.DeepCopyInto(&out.ApplicationSource)
+	if in.SyncPolicy != nil {
+		in, out := &in.SyncPolicy, &out.SyncPolicy
+		*out = new(applicationv1alpha1.SyncPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSourceSpec.
+func (in *AppSourceSpec) DeepCopy() *AppSourceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AppSourceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppSourceStatus) DeepCopyInto(out *AppSourceStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]AppSourceCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Profile != nil {
+		in, out := &in.Profile, &out.Profile
+		*out = new(AppSourceProfileMatch)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReconciledAt != nil {
+		in, out := &in.ReconciledAt, &out.ReconciledAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(AppSourceResourceSummary)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppSourceStatus.
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+const (
+	// BackendAPI manages Applications and Projects through the ArgoCD API server
+	BackendAPI = "api"
+	// BackendKubernetes manages Applications and Projects as Kubernetes resources in the ArgoCD namespace
+	BackendKubernetes = "kubernetes"
+)
+
+// ArgoCDBackend reads and writes the ArgoCD Applications and Projects managed by the controller.
+// Missing Applications and Projects are reported with errors for which isNotFound returns true
+type ArgoCDBackend interface {
+	// Instance returns the name of the ArgoCD instance managed by the backend
+	Instance() string
+
+	GetApplication(ctx context.Context, name string) (*v1alpha1.Application, error)
+	// ListApplications returns the Applications of the project
+	ListApplications(ctx context.Context, project string) ([]v1alpha1.Application, error)
+	CreateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error)
+	UpdateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error)
+	// DeleteApplication deletes the Application, its resources are deleted according to mode
+	DeleteApplication(ctx context.Context, name string, mode appsource.DeletionMode) error
+
+	GetProject(ctx context.Context, name string) (*v1alpha1.AppProject, error)
+	CreateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error)
+	UpdateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error)
+	DeleteProject(ctx context.Context, name string) error
+}
+
+// isNotFound returns true if err reports a missing Application or Project, from either backend
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound || apierrors.IsNotFound(err)
+}
+
+// argoCDBackend returns the backend selected by the AppSource config for the named ArgoCD instance
+func (r *AppSourceReconciler) argoCDBackend(ctx context.Context, config *AppSourceConfig, name string) (ArgoCDBackend, error) {
+	instance, err := config.Instance(name)
+	if err != nil {
+		return nil, err
+	}
+	if config.Backend == BackendKubernetes {
+		namespace := instance.Namespace
+		if namespace == "" {
+			namespace = r.ArgocdNS
+		}
+		return &kubernetesBackend{Client: r.Client, Reader: r.APIReader, Namespace: namespace, instance: instance.Name}, nil
+	}
+	clients, err := r.UpsertArgoCDClients(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	return &apiBackend{clients: clients, manager: r.ArgoCD, instance: instance.Name}, nil
+}
+
+// argocdInstanceName returns the name of the ArgoCD instance holding the Application of the AppSource. The instance
+// recorded in the status is kept once the Application exists, so profile changes do not orphan existing Applications.
+// proj may be nil, an empty name selects the default instance
+func argocdInstanceName(appSource *appsource.AppSource, proj *ProjectTemplate) string {
+	if appSource.Status.ArgocdInstance != "" {
+		return appSource.Status.ArgocdInstance
+	}
+	if proj == nil {
+		return ""
+	}
+	return proj.ArgocdInstance
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	projectTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// apiBackend manages Applications and Projects through the ArgoCD API server. Failed calls are
+// reported to the ClientManager, which reconnects if the API server was unreachable
+type apiBackend struct {
+	clients  *ArgoCDClients
+	manager  *ClientManager
+	instance string
+}
+
+func (b *apiBackend) Instance() string {
+	return b.instance
+}
+
+// report reports err to the ClientManager and returns it
+func (b *apiBackend) report(err error) error {
+	b.manager.ReportError(b.clients, err)
+	return err
+}
+
+func (b *apiBackend) GetApplication(ctx context.Context, name string) (*v1alpha1.Application, error) {
+	app, err := b.clients.Applications.Client.Get(ctx, &applicationTypes.ApplicationQuery{Name: &name})
+	return app, b.report(err)
+}
+
+func (b *apiBackend) ListApplications(ctx context.Context, project string) ([]v1alpha1.Application, error) {
+	apps, err := b.clients.Applications.Client.List(ctx, &applicationTypes.ApplicationQuery{Projects: []string{project}})
+	if err != nil {
+		return nil, b.report(err)
+	}
+	return apps.Items, nil
+}
+
+func (b *apiBackend) CreateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
+	created, err := b.clients.Applications.Client.Create(ctx, &applicationTypes.ApplicationCreateRequest{Application: *app})
+	return created, b.report(err)
+}
+
+func (b *apiBackend) UpdateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
+	updated, err := b.clients.Applications.Client.Update(ctx, &applicationTypes.ApplicationUpdateRequest{Application: app})
+	return updated, b.report(err)
+}
+
+func (b *apiBackend) DeleteApplication(ctx context.Context, name string, mode appsource.DeletionMode) error {
+	request := &applicationTypes.ApplicationDeleteRequest{Name: &name}
+	switch mode {
+	case appsource.DeletionModeOrphan:
+		request.Cascade = &cascadeFalse
+	case appsource.DeletionModeCascadeForeground:
+		request.Cascade = &cascadeTrue
+		request.PropagationPolicy = &foreground
+	case appsource.DeletionModeCascadeBackground:
+		request.Cascade = &cascadeTrue
+		request.PropagationPolicy = &background
+	default:
+		return fmt.Errorf("invalid deletion mode %s", mode)
+	}
+	_, err := b.clients.Applications.Client.Delete(ctx, request)
+	return b.report(err)
+}
+
+func (b *apiBackend) GetProject(ctx context.Context, name string) (*v1alpha1.AppProject, error) {
+	project, err := b.clients.Projects.Client.Get(ctx, &projectTypes.ProjectQuery{Name: name})
+	return project, b.report(err)
+}
+
+func (b *apiBackend) CreateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error) {
+	created, err := b.clients.Projects.Client.Create(ctx, &projectTypes.ProjectCreateRequest{Project: project, Upsert: false})
+	return created, b.report(err)
+}
+
+func (b *apiBackend) UpdateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error) {
+	updated, err := b.clients.Projects.Client.Update(ctx, &projectTypes.ProjectUpdateRequest{Project: project})
+	return updated, b.report(err)
+}
+
+func (b *apiBackend) DeleteProject(ctx context.Context, name string) error {
+	_, err := b.clients.Projects.Client.Delete(ctx, &projectTypes.ProjectQuery{Name: name})
+	return b.report(err)
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-cd/v2/common"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// kubernetesBackend manages Applications and Projects as Kubernetes resources in the ArgoCD namespace,
+// relying on Kubernetes RBAC instead of an ArgoCD API token. ArgoCD API server validations are not applied
+type kubernetesBackend struct {
+	// Client used to write resources
+	Client client.Client
+	// Reader used to read resources, expected to bypass the manager cache
+	Reader client.Reader
+	// ArgoCD namespace
+	Namespace string
+	// Name of the ArgoCD instance
+	instance string
+}
+
+func (b *kubernetesBackend) Instance() string {
+	return b.instance
+}
+
+func (b *kubernetesBackend) GetApplication(ctx context.Context, name string) (*v1alpha1.Application, error) {
+	app := &v1alpha1.Application{}
+	if err := b.Reader.Get(ctx, types.NamespacedName{Namespace: b.Namespace, Name: name}, app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func (b *kubernetesBackend) ListApplications(ctx context.Context, project string) ([]v1alpha1.Application, error) {
+	apps := &v1alpha1.ApplicationList{}
+	if err := b.Reader.List(ctx, apps, client.InNamespace(b.Namespace)); err != nil {
+		return nil, err
+	}
+	var result []v1alpha1.Application
+	for _, app := range apps.Items {
+		if app.Spec.Project == project {
+			result = append(result, app)
+		}
+	}
+	return result, nil
+}
+
+func (b *kubernetesBackend) CreateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
+	created := app.DeepCopy()
+	created.Namespace = b.Namespace
+	if err := b.Client.Create(ctx, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (b *kubernetesBackend) UpdateApplication(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
+	updated := app.DeepCopy()
+	if err := b.Client.Update(ctx, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteApplication sets the ArgoCD resources finalizer matching mode before deleting the Application,
+// as the ArgoCD API server does. ArgoCD deletes the Application resources before releasing the finalizer
+func (b *kubernetesBackend) DeleteApplication(ctx context.Context, name string, mode appsource.DeletionMode) error {
+	app, err := b.GetApplication(ctx, name)
+	if err != nil {
+		return err
+	}
+	live := append([]string{}, app.Finalizers...)
+	switch mode {
+	case appsource.DeletionModeOrphan:
+		app.UnSetCascadedDeletion()
+	case appsource.DeletionModeCascadeForeground:
+		app.UnSetCascadedDeletion()
+		app.SetCascadedDeletion(common.ForegroundPropagationPolicyFinalizer)
+	case appsource.DeletionModeCascadeBackground:
+		app.UnSetCascadedDeletion()
+		app.SetCascadedDeletion(common.BackgroundPropagationPolicyFinalizer)
+	default:
+		return fmt.Errorf("invalid deletion mode %s", mode)
+	}
+	if !equality.Semantic.DeepEqual(app.Finalizers, live) {
+		if err := b.Client.Update(ctx, app); err != nil {
+			return err
+		}
+	}
+	return b.Client.Delete(ctx, app)
+}
+
+func (b *kubernetesBackend) GetProject(ctx context.Context, name string) (*v1alpha1.AppProject, error) {
+	project := &v1alpha1.AppProject{}
+	if err := b.Reader.Get(ctx, types.NamespacedName{Namespace: b.Namespace, Name: name}, project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (b *kubernetesBackend) CreateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error) {
+	created := project.DeepCopy()
+	created.Namespace = b.Namespace
+	if err := b.Client.Create(ctx, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (b *kubernetesBackend) UpdateProject(ctx context.Context, project *v1alpha1.AppProject) (*v1alpha1.AppProject, error) {
+	updated := project.DeepCopy()
+	if err := b.Client.Update(ctx, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (b *kubernetesBackend) DeleteProject(ctx context.Context, name string) error {
+	return b.Client.Delete(ctx, &v1alpha1.AppProject{
+		ObjectMeta: metav1.ObjectMeta{Namespace: b.Namespace, Name: name},
+	})
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/argoproj/argo-cd/v2/common"
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("Kubernetes backend", func() {
+	var (
+		ctx     context.Context
+		k8s     client.Client
+		backend *kubernetesBackend
+	)
+	newApplication := func(name, project string, finalizers ...string) *argocd.Application {
+		return &argocd.Application{
+			ObjectMeta: metav1.ObjectMeta{Namespace: appsource.ArgocdNamespace, Name: name, Finalizers: finalizers},
+			Spec:       argocd.ApplicationSpec{Project: project},
+		}
+	}
+	// newBackend returns a backend backed by a fake Kubernetes client holding objects
+	newBackend := func(objects ...client.Object) {
+		scheme := runtime.NewScheme()
+		Expect(argocd.AddToScheme(scheme)).To(Succeed())
+		k8s = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		backend = &kubernetesBackend{Client: k8s, Reader: k8s, Namespace: appsource.ArgocdNamespace}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("creates Applications and Projects in the ArgoCD namespace", func() {
+		newBackend()
+		project, err := backend.CreateProject(ctx, &argocd.AppProject{ObjectMeta: metav1.ObjectMeta{Name: "my-project"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(project.Namespace).To(Equal(appsource.ArgocdNamespace))
+		_, err = backend.CreateApplication(ctx, newApplication("guestbook", "my-project"))
+		Expect(err).NotTo(HaveOccurred())
+
+		app, err := backend.GetApplication(ctx, "guestbook")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.Spec.Project).To(Equal("my-project"))
+		_, err = backend.GetProject(ctx, "my-project")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports missing Applications and Projects as not found", func() {
+		newBackend()
+		_, err := backend.GetApplication(ctx, "guestbook")
+		Expect(isNotFound(err)).To(BeTrue())
+		_, err = backend.GetProject(ctx, "my-project")
+		Expect(isNotFound(err)).To(BeTrue())
+		Expect(isNotFound(backend.DeleteProject(ctx, "my-project"))).To(BeTrue())
+	})
+
+	It("lists the Applications of a project", func() {
+		newBackend(newApplication("guestbook", "my-project"), newApplication("helm-guestbook", "other-project"))
+		apps, err := backend.ListApplications(ctx, "my-project")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apps).To(HaveLen(1))
+		Expect(apps[0].Name).To(Equal("guestbook"))
+	})
+
+	It("removes the ArgoCD resources finalizer before orphaning an Application", func() {
+		newBackend(newApplication("guestbook", "my-project", common.ResourcesFinalizerName))
+		finalizers := []string{}
+		backend.Client = interceptUpdates(k8s, func(app *argocd.Application) { finalizers = app.Finalizers })
+		Expect(backend.DeleteApplication(ctx, "guestbook", appsource.DeletionModeOrphan)).To(Succeed())
+		Expect(finalizers).To(BeEmpty())
+
+		_, err := backend.GetApplication(ctx, "guestbook")
+		Expect(isNotFound(err)).To(BeTrue())
+	})
+
+	It("sets the ArgoCD resources finalizer matching the deletion mode", func() {
+		newBackend(newApplication("guestbook", "my-project", "example.com/keep"))
+		var finalizers []string
+		backend.Client = interceptUpdates(k8s, func(app *argocd.Application) { finalizers = app.Finalizers })
+		Expect(backend.DeleteApplication(ctx, "guestbook", appsource.DeletionModeCascadeForeground)).To(Succeed())
+		Expect(finalizers).To(ConsistOf("example.com/keep", common.ForegroundPropagationPolicyFinalizer))
+	})
+
+	It("rejects invalid deletion modes", func() {
+		newBackend(newApplication("guestbook", "my-project"))
+		Expect(backend.DeleteApplication(ctx, "guestbook", "cascade")).NotTo(Succeed())
+		_, err := backend.GetApplication(ctx, "guestbook")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is selected by the argocd.backend config", func() {
+		configMap := &v1.ConfigMap{Data: map[string]string{"argocd.backend": "kubernetes"}}
+		config, err := ParseAppSourceConfig(configMap, appsource.ArgocdNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		r := &AppSourceReconciler{ArgocdNS: appsource.ArgocdNamespace}
+		selected, err := r.argoCDBackend(ctx, config, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(BeAssignableToTypeOf(&kubernetesBackend{}))
+
+		configMap.Data["argocd.backend"] = "grpc"
+		_, err = ParseAppSourceConfig(configMap, appsource.ArgocdNamespace)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads Applications with the uncached reader", func() {
+		newBackend()
+		reader := fake.NewClientBuilder().WithScheme(k8s.Scheme()).WithObjects(newApplication("guestbook", "my-project")).Build()
+		backend.Reader = reader
+		_, err := backend.GetApplication(ctx, "guestbook")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8s.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: "guestbook"}, &argocd.Application{})).NotTo(Succeed())
+	})
+})
+
+// updateInterceptor calls onUpdate with every Application updated through the client
+type updateInterceptor struct {
+	client.Client
+	onUpdate func(app *argocd.Application)
+}
+
+func interceptUpdates(c client.Client, onUpdate func(app *argocd.Application)) client.Client {
+	return &updateInterceptor{Client: c, onUpdate: onUpdate}
+}
+
+func (c *updateInterceptor) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if app, ok := obj.(*argocd.Application); ok {
+		c.onUpdate(app.DeepCopy())
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
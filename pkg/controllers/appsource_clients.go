@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	argocdClientSet "github.com/argoproj/argo-cd/v2/pkg/apiclient"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultCloseGracePeriod is the time replaced clients are kept open by default
+const defaultCloseGracePeriod = 30 * time.Second
+
+// ClientManager holds long-lived ArgoCD API clients shared by all reconciles, one set per ArgoCD instance.
+// Clients are only rebuilt when the client options change or a connection failure is reported. Replaced
+// clients are closed after a grace period, so calls of concurrent reconciles still using them can complete
+type ClientManager struct {
+	// NewClients connects new clients, defaults to dialing the ArgoCD API server. Tests replace it with a fake
+	NewClients func(opts *argocdClientSet.ClientOptions) (*ArgoCDClients, error)
+	// Time replaced clients are kept open before being closed, defaults to 30 seconds
+	CloseGracePeriod time.Duration
+
+	lock      sync.Mutex
+	instances map[string]*instanceClients
+	// Replaced clients waiting for their grace period to close them
+	retired map[*ArgoCDClients]*time.Timer
+}
+
+// instanceClients holds the clients of an ArgoCD instance
+type instanceClients struct {
+	// Held while connecting, so each instance is dialed once at a time without blocking other instances
+	dialLock sync.Mutex
+	// Options of the last connection attempt
+	opts    *argocdClientSet.ClientOptions
+	clients *ArgoCDClients
+	// Error of the last connection attempt or of the last failed call on the current clients
+	lastErr error
+}
+
+// Clients returns API clients of the named ArgoCD instance connected with opts, reusing the
+// current clients if they were built with the same options and are still healthy
+func (m *ClientManager) Clients(instance string, opts *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+	current := m.instance(instance)
+	current.dialLock.Lock()
+	defer current.dialLock.Unlock()
+
+	// Concurrent callers waiting on the dial lock reuse the clients connected meanwhile
+	m.lock.Lock()
+	if current.clients != nil && reflect.DeepEqual(current.opts, opts) {
+		m.lock.Unlock()
+		return current.clients, nil
+	}
+	m.lock.Unlock()
+	return m.dial(current, opts)
+}
+
+// dial replaces the clients of an instance with clients connected with opts, outside of the lock.
+// It must be called with the instance dial lock held
+func (m *ClientManager) dial(current *instanceClients, opts *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+	m.lock.Lock()
+	m.retire(current)
+	m.lock.Unlock()
+
+	newClients := m.NewClients
+	if newClients == nil {
+		newClients = newArgoCDClients
+	}
+	clients, err := newClients(opts)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	current.opts = opts
+	current.clients = clients
+	current.lastErr = err
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// instance returns the clients of the named ArgoCD instance, adding them if necessary
+func (m *ClientManager) instance(name string) *instanceClients {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.instances == nil {
+		m.instances = map[string]*instanceClients{}
+	}
+	current, ok := m.instances[name]
+	if !ok {
+		current = &instanceClients{}
+		m.instances[name] = current
+	}
+	return current
+}
+
+// ReportError marks clients as broken if err indicates the ArgoCD API server is unreachable,
+// so that the next call to Clients reconnects
+func (m *ClientManager) ReportError(clients *ArgoCDClients, err error) {
+	if err == nil || status.Code(err) != codes.Unavailable {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, current := range m.instances {
+		if current.clients == clients {
+			m.retire(current)
+			current.lastErr = err
+			return
+		}
+	}
+	// Clients were already replaced
+}
+
+// Invalidate retires the current clients of the named ArgoCD instance, so that the next call to Clients
+// reconnects even if the client options are unchanged
+func (m *ClientManager) Invalidate(instance string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if current, ok := m.instances[instance]; ok {
+		m.retire(current)
+	}
+}
+
+// ReadyzCheck reports the state of the ArgoCD API connections. Instances whose last connection attempt
+// or call failed are dialed again with their last options, the check fails while any of them cannot connect
+func (m *ClientManager) ReadyzCheck(_ *http.Request) error {
+	m.lock.Lock()
+	failed := map[string]*argocdClientSet.ClientOptions{}
+	for name, current := range m.instances {
+		if current.lastErr != nil {
+			failed[name] = current.opts
+		}
+	}
+	m.lock.Unlock()
+
+	var unavailable []string
+	for name, opts := range failed {
+		if err := m.redial(name, opts); err != nil {
+			unavailable = append(unavailable, name+": "+err.Error())
+		}
+	}
+	if len(unavailable) > 0 {
+		sort.Strings(unavailable)
+		return errors.New("argocd api server unavailable: " + strings.Join(unavailable, "; "))
+	}
+	return nil
+}
+
+// redial connects the named instance again with opts after a failure, unless a reconcile
+// reconnected it or changed its options meanwhile
+func (m *ClientManager) redial(name string, opts *argocdClientSet.ClientOptions) error {
+	current := m.instance(name)
+	current.dialLock.Lock()
+	defer current.dialLock.Unlock()
+	m.lock.Lock()
+	lastErr, changed := current.lastErr, current.opts != opts
+	m.lock.Unlock()
+	if lastErr == nil || changed {
+		return lastErr
+	}
+	_, err := m.dial(current, opts)
+	return err
+}
+
+// Close closes the current and replaced clients of every ArgoCD instance
+func (m *ClientManager) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, current := range m.instances {
+		if current.clients != nil {
+			current.clients.close()
+			current.clients = nil
+		}
+	}
+	for clients, timer := range m.retired {
+		if timer.Stop() {
+			clients.close()
+		}
+	}
+	m.retired = nil
+}
+
+// retire detaches the current clients of an instance and closes them once the grace period elapsed.
+// It must be called with the lock held
+func (m *ClientManager) retire(current *instanceClients) {
+	clients := current.clients
+	if clients == nil {
+		return
+	}
+	current.clients = nil
+	gracePeriod := m.CloseGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultCloseGracePeriod
+	}
+	if m.retired == nil {
+		m.retired = map[*ArgoCDClients]*time.Timer{}
+	}
+	m.retired[clients] = time.AfterFunc(gracePeriod, func() {
+		m.lock.Lock()
+		delete(m.retired, clients)
+		m.lock.Unlock()
+		clients.close()
+	})
+}
+
+// close closes the Application and Project clients
+func (c *ArgoCDClients) close() {
+	c.Applications.Closer.Close()
+	c.Projects.Closer.Close()
+}
+
+// newArgoCDClients connects new Application and Project clients to the ArgoCD API server
+func newArgoCDClients(opts *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+	argocdClient, err := argocdClientSet.NewClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := &ArgoCDClients{}
+	clients.Applications.Closer, clients.Applications.Client, err = argocdClient.NewApplicationClient()
+	if err != nil {
+		return nil, err
+	}
+	clients.Projects.Closer, clients.Projects.Client, err = argocdClient.NewProjectClient()
+	if err != nil {
+		clients.Applications.Closer.Close()
+		return nil, err
+	}
+	// Record metrics for every ArgoCD API call
+	clients.Applications.Client = &instrumentedApplicationClient{clients.Applications.Client}
+	clients.Projects.Client = &instrumentedProjectClient{clients.Projects.Client}
+	return clients, nil
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	argocdClientSet "github.com/argoproj/argo-cd/v2/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countingCloser counts the calls to Close
+type countingCloser struct {
+	closed int32
+}
+
+func (c *countingCloser) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *countingCloser) count() int32 {
+	return atomic.LoadInt32(&c.closed)
+}
+
+var _ = Describe("ArgoCD client manager", func() {
+	var (
+		dials    int32
+		dialErr  error
+		closers  []*countingCloser
+		lock     sync.Mutex
+		releases chan struct{}
+		manager  *ClientManager
+	)
+	opts := &argocdClientSet.ClientOptions{ServerAddr: "argocd-server.argocd.svc:443", AuthToken: "token"}
+
+	BeforeEach(func() {
+		dials, dialErr, closers, releases = 0, nil, nil, nil
+		manager = &ClientManager{
+			CloseGracePeriod: 50 * time.Millisecond,
+			NewClients: func(_ *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+				atomic.AddInt32(&dials, 1)
+				if releases != nil {
+					<-releases
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				if dialErr != nil {
+					return nil, dialErr
+				}
+				closer := &countingCloser{}
+				closers = append(closers, closer)
+				clients := &ArgoCDClients{}
+				clients.Applications.Closer = closer
+				clients.Projects.Closer = closer
+				return clients, nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		manager.Close()
+	})
+
+	It("reuses clients until the options change, closing replaced clients after a grace period", func() {
+		clients, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		same, err := manager.Clients(defaultInstance, &argocdClientSet.ClientOptions{ServerAddr: opts.ServerAddr, AuthToken: "token"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(same).To(BeIdenticalTo(clients))
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+
+		rotated, err := manager.Clients(defaultInstance, &argocdClientSet.ClientOptions{ServerAddr: opts.ServerAddr, AuthToken: "rotated"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated).NotTo(BeIdenticalTo(clients))
+		// Calls in flight on the replaced clients can still complete
+		Expect(closers[0].count()).To(BeZero())
+		Eventually(closers[0].count).Should(BeEquivalentTo(2))
+		Expect(closers[1].count()).To(BeZero())
+	})
+
+	It("keeps the clients of each instance apart", func() {
+		clients, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		payments, err := manager.Clients("payments", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payments).NotTo(BeIdenticalTo(clients))
+
+		manager.Invalidate("payments")
+		again, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(clients))
+		again, err = manager.Clients("payments", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).NotTo(BeIdenticalTo(payments))
+	})
+
+	It("dials an instance once for concurrent callers", func() {
+		releases = make(chan struct{})
+		results := make(chan *ArgoCDClients, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer GinkgoRecover()
+				clients, err := manager.Clients(defaultInstance, opts)
+				Expect(err).NotTo(HaveOccurred())
+				results <- clients
+			}()
+		}
+		Eventually(func() int32 { return atomic.LoadInt32(&dials) }).Should(BeEquivalentTo(1))
+		// The manager lock is not held while dialing
+		Expect(manager.ReadyzCheck(nil)).To(Succeed())
+		close(releases)
+
+		var first, second *ArgoCDClients
+		Eventually(results).Should(Receive(&first))
+		Eventually(results).Should(Receive(&second))
+		Expect(second).To(BeIdenticalTo(first))
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+	})
+
+	It("reconnects after the ArgoCD API server was reported unavailable", func() {
+		clients, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		manager.ReportError(clients, status.Error(codes.NotFound, "not found"))
+		Expect(manager.ReadyzCheck(nil)).To(Succeed())
+		same, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(same).To(BeIdenticalTo(clients))
+
+		manager.ReportError(clients, status.Error(codes.Unavailable, "connection refused"))
+		Eventually(closers[0].count).Should(BeEquivalentTo(2))
+		reconnected, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconnected).NotTo(BeIdenticalTo(clients))
+
+		// Errors reported on replaced clients are ignored
+		manager.ReportError(clients, status.Error(codes.Unavailable, "connection refused"))
+		again, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(reconnected))
+	})
+
+	It("redials failed instances when checked for readiness", func() {
+		dialErr = errors.New("connection refused")
+		_, err := manager.Clients(defaultInstance, opts)
+		Expect(err).To(HaveOccurred())
+		Expect(manager.ReadyzCheck(nil)).To(MatchError("argocd api server unavailable: default: connection refused"))
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(2))
+
+		lock.Lock()
+		dialErr = nil
+		lock.Unlock()
+		Expect(manager.ReadyzCheck(nil)).To(Succeed())
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(3))
+		// The redialed clients are reused
+		_, err = manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(3))
+		Expect(manager.ReadyzCheck(nil)).To(Succeed())
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(3))
+	})
+
+	It("closes current and replaced clients", func() {
+		manager.CloseGracePeriod = time.Hour
+		_, err := manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+		manager.Invalidate(defaultInstance)
+		_, err = manager.Clients(defaultInstance, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		manager.Close()
+		Expect(closers[0].count()).To(BeEquivalentTo(2))
+		Expect(closers[1].count()).To(BeEquivalentTo(2))
+	})
+})
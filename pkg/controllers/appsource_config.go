@@ -2,39 +2,175 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 
 	argocdClientSet "github.com/argoproj/argo-cd/v2/pkg/apiclient"
 	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/glob"
 	"github.com/ghodss/yaml"
 	"github.com/kballard/go-shellquote"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
 )
 
 const (
-	//AppSource configmap name
-	appSourceCM = "argocd-appsource-cm"
+	// defaultInstance names the ArgoCD instance configured by the top-level argocd.* keys
+	defaultInstance = "default"
 )
 
-var (
-	flags map[string]string
+// clientFlags holds the flags found in the argocd.clientOpts string
+type clientFlags map[string]string
+
+// TokenSecretRef references the key of a Kubernetes Secret holding the ArgoCD API token
+type TokenSecretRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// ApplicationTemplate holds the defaults merged into every Application created from a profile
+type ApplicationTemplate struct {
+	Labels               map[string]string                  `json:"labels,omitempty"`
+	Annotations          map[string]string                  `json:"annotations,omitempty"`
+	SyncPolicy           *argocd.SyncPolicy                 `json:"syncPolicy,omitempty"`
+	RevisionHistoryLimit *int64                             `json:"revisionHistoryLimit,omitempty"`
+	IgnoreDifferences    []argocd.ResourceIgnoreDifferences `json:"ignoreDifferences,omitempty"`
+	AllowedSyncPolicy    *AllowedSyncPolicy                 `json:"allowedSyncPolicy,omitempty"`
+}
+
+const (
+	//Reject AppSources requesting sync policy settings which are not allowed
+	SyncPolicyEnforcementReject = "reject"
+	//Drop requested sync policy settings which are not allowed
+	SyncPolicyEnforcementClamp = "clamp"
 )
 
+// AllowedSyncPolicy declares which sync policy settings AppSource users may request
+type AllowedSyncPolicy struct {
+	Automated  bool `json:"automated,omitempty"`
+	Prune      bool `json:"prune,omitempty"`
+	SelfHeal   bool `json:"selfHeal,omitempty"`
+	AllowEmpty bool `json:"allowEmpty,omitempty"`
+	Retry      bool `json:"retry,omitempty"`
+	// Glob patterns of the sync options users may set, e.g. CreateNamespace=*
+	SyncOptions []string `json:"syncOptions,omitempty"`
+	// Enforcement is either reject (default) or clamp
+	Enforcement string `json:"enforcement,omitempty"`
+}
+
+// clamps returns true if disallowed settings are dropped rather than rejected
+func (allowed *AllowedSyncPolicy) clamps() bool {
+	return allowed != nil && allowed.Enforcement == SyncPolicyEnforcementClamp
+}
+
 type ProjectTemplate struct {
-	NamePattern     string                 `json:"namePattern"`
-	Spec            *argocd.AppProjectSpec `json:"spec,omitempty"`
-	PatternCompiler *regexp.Regexp         `json:"omitempty"`
+	NamePattern string `json:"namePattern,omitempty"`
+	// Namespace name patterns the profile never matches, even if its other matchers do
+	ExcludeNamePatterns []string `json:"excludeNamePatterns,omitempty"`
+	// Profiles with a higher priority are matched first, profiles of equal priority in configmap order
+	Priority int `json:"priority,omitempty"`
+	// Labels the namespace must match
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Glob patterns the namespace annotations must match, by annotation key
+	NamespaceAnnotations map[string]string `json:"namespaceAnnotations,omitempty"`
+	// Go template of the ArgoCD project name, given the same data as applicationNameTemplates
+	ProjectNameTemplate     string                 `json:"projectNameTemplate,omitempty"`
+	Spec                    *argocd.AppProjectSpec `json:"spec,omitempty"`
+	ApplicationTemplate     *ApplicationTemplate   `json:"applicationTemplate,omitempty"`
+	ApplicationNameTemplate string                 `json:"applicationNameTemplate,omitempty"`
+	PruneProject            bool                   `json:"pruneProject,omitempty"`
+	DeletionMode            string                 `json:"deletionMode,omitempty"`
+	Destination             *DestinationTemplate   `json:"destination,omitempty"`
+	ArgocdInstance          string                 `json:"argocdInstance,omitempty"`
+	PatternCompiler         *regexp.Regexp         `json:"-"`
+	ExcludeCompilers        []*regexp.Regexp       `json:"-"`
+	NameTemplate            *template.Template     `json:"-"`
+	Selector                labels.Selector        `json:"-"`
+	ProjectNameCompiler     *template.Template     `json:"-"`
+	// Name of the profile
+	Name string `json:"-"`
+	// Labels and annotations of the namespace the profile was matched with by FindProject
+	namespaceLabels      map[string]string
+	namespaceAnnotations map[string]string
 }
 
-// getFlag returns flags[key] or fallback string if key does not exist
-func getFlag(key, fallback string) string {
+// DestinationTemplate selects the cluster Applications created from a profile are deployed to, either by
+// server URL or by ArgoCD cluster name. Both are Go templates given the same data as applicationNameTemplates
+type DestinationTemplate struct {
+	Server         string             `json:"server,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	ServerTemplate *template.Template `json:"-"`
+	NameTemplate   *template.Template `json:"-"`
+}
+
+// ApplicationNameData is the data available to profile applicationNameTemplates, destination templates
+// and projectNameTemplates
+type ApplicationNameData struct {
+	// Namespace of the AppSource
+	Namespace string
+	// Name of the AppSource
+	Name string
+	// Project is the ArgoCD project name, empty in projectNameTemplates
+	Project string
+	// Groups holds the namePattern capturing groups by name and by index
+	Groups map[string]string
+	// Labels of the AppSource namespace
+	Labels map[string]string
+	// Annotations of the AppSource namespace
+	Annotations map[string]string
+}
+
+// AppSourceConfig holds the parsed content of the AppSource configmap
+type AppSourceConfig struct {
+	// ResourceVersion of the configmap this config was parsed from
+	ResourceVersion string
+	// Backend used to manage Applications and Projects, api or kubernetes
+	Backend string
+	// ArgoCD instances by name, the default instance is configured by the top-level argocd.* keys
+	Instances map[string]*ArgoCDInstance
+	// ArgoCD Project Templates, in the order they are matched
+	ProjectProfiles []*ProjectTemplate
+}
+
+// ArgoCDInstance holds the connection settings of an ArgoCD instance
+type ArgoCDInstance struct {
+	// Name of the instance
+	Name string
+	// ArgoCD Server address
+	ServerAddr string
+	// ArgoCD API Client Options
+	ClientOpts clientFlags
+	// Secret holding the ArgoCD API token, nil if the token is read from the environment
+	TokenSecretRef *TokenSecretRef
+	// Namespace holding the Applications and Projects of the instance, used by the kubernetes backend.
+	// Empty for the controller ArgoCD namespace
+	Namespace string
+}
+
+// instanceSpec is an entry of the argocd.instances value
+type instanceSpec struct {
+	Address        string          `json:"address"`
+	ClientOpts     string          `json:"clientOpts,omitempty"`
+	TokenSecretRef *TokenSecretRef `json:"tokenSecretRef,omitempty"`
+	Namespace      string          `json:"namespace,omitempty"`
+}
+
+// get returns flags[key] or fallback string if key does not exist
+func (flags clientFlags) get(key, fallback string) string {
 	val, ok := flags[key]
 	if ok {
 		return val
@@ -42,20 +178,20 @@ func getFlag(key, fallback string) string {
 	return fallback
 }
 
-// getBoolFlag returns flags[key] boolean or false if key
+// getBool returns flags[key] boolean or false if key
 // does not exist
-func getBoolFlag(key string) bool {
-	return getFlag(key, "false") == "true"
+func (flags clientFlags) getBool(key string) bool {
+	return flags.get(key, "false") == "true"
 }
 
-// loadFlags populates the flags map with any keys and
+// loadFlags returns a map with any keys and
 // values found in the clientOpts string
-func loadFlags(clientOpts string) (err error) {
+func loadFlags(clientOpts string) (clientFlags, error) {
 	opts, err := shellquote.Split(clientOpts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	flags = make(map[string]string)
+	flags := make(clientFlags)
 	var key string
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--") {
@@ -67,131 +203,422 @@ func loadFlags(clientOpts string) (err error) {
 			flags[key] = opt
 			key = ""
 		} else {
-			return errors.New("clientOpts invalid at '" + opt + "'")
+			return nil, errors.New("clientOpts invalid at '" + opt + "'")
 		}
 	}
 	if key != "" {
 		flags[key] = "true"
 	}
 
-	return nil
+	return flags, nil
 }
 
-// GetClientOpts loads all the flags found in the AppSource configmap
-// and returns a ArgoCD ClientOpts object with any fields found
-func (r *AppSourceReconciler) GetClientOpts() (*argocdClientSet.ClientOptions, error) {
-	err := loadFlags(r.ConfigMap.Data["argocd.clientOpts"])
+// ParseAppSourceConfig parses and validates the AppSource configmap, Secret references
+// without a namespace default to defaultNamespace
+func ParseAppSourceConfig(configMap *v1.ConfigMap, defaultNamespace string) (*AppSourceConfig, error) {
+	flags, err := loadFlags(configMap.Data["argocd.clientOpts"])
+	if err != nil {
+		return nil, err
+	}
+	tokenSecretRef, err := parseTokenSecretRef(configMap.Data["argocd.tokenSecretRef"], defaultNamespace)
 	if err != nil {
 		return nil, err
 	}
+	instances, err := parseInstances(configMap.Data["argocd.instances"], defaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	instances[defaultInstance] = &ArgoCDInstance{
+		Name:           defaultInstance,
+		ServerAddr:     configMap.Data["argocd.address"],
+		ClientOpts:     flags,
+		TokenSecretRef: tokenSecretRef,
+	}
+	profiles, err := parseProjectProfiles(configMap.Data["project.profiles"])
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range profiles {
+		if _, ok := instances[project.ArgocdInstance]; project.ArgocdInstance != "" && !ok {
+			return nil, fmt.Errorf("profile %s references unknown argocdInstance %s", project.Name, project.ArgocdInstance)
+		}
+	}
+	backend := configMap.Data["argocd.backend"]
+	switch backend {
+	case "":
+		backend = BackendAPI
+	case BackendAPI, BackendKubernetes:
+	default:
+		return nil, fmt.Errorf("invalid argocd.backend %s, expected %s or %s", backend, BackendAPI, BackendKubernetes)
+	}
+	return &AppSourceConfig{
+		ResourceVersion: configMap.ResourceVersion,
+		Backend:         backend,
+		Instances:       instances,
+		ProjectProfiles: profiles,
+	}, nil
+}
 
-	token := os.Getenv("ARGOCD_TOKEN")
+// parseInstances parses the argocd.instances value, a map of ArgoCD instances by name
+func parseInstances(data, defaultNamespace string) (map[string]*ArgoCDInstance, error) {
+	specs := map[string]*instanceSpec{}
+	if err := yaml.Unmarshal([]byte(data), &specs); err != nil {
+		return nil, err
+	}
+	instances := map[string]*ArgoCDInstance{}
+	for name, spec := range specs {
+		if name == defaultInstance {
+			return nil, fmt.Errorf("argocd instance name %s is reserved for the argocd.address instance", defaultInstance)
+		}
+		if spec == nil || spec.Address == "" {
+			return nil, fmt.Errorf("argocd instance %s has no address", name)
+		}
+		flags, err := loadFlags(spec.ClientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("argocd instance %s has invalid clientOpts: %v", name, err)
+		}
+		if ref := spec.TokenSecretRef; ref != nil {
+			if ref.Name == "" || ref.Key == "" {
+				return nil, fmt.Errorf("argocd instance %s tokenSecretRef requires a name and a key", name)
+			}
+			if ref.Namespace == "" {
+				ref.Namespace = defaultNamespace
+			}
+		}
+		instances[name] = &ArgoCDInstance{
+			Name:           name,
+			ServerAddr:     spec.Address,
+			ClientOpts:     flags,
+			TokenSecretRef: spec.TokenSecretRef,
+			Namespace:      spec.Namespace,
+		}
+	}
+	return instances, nil
+}
 
-	return &argocdClientSet.ClientOptions{
-		ServerAddr:           r.ConfigMap.Data["argocd.address"],
-		AuthToken:            token,
-		PlainText:            getBoolFlag("plaintext"),
-		Insecure:             getBoolFlag("insecure"),
-		CertFile:             getFlag("server-crt", ""),
-		ClientCertFile:       getFlag("client-crt", ""),
-		ClientCertKeyFile:    getFlag("client-crt-key", ""),
-		GRPCWeb:              getBoolFlag("grpc-web"),
-		GRPCWebRootPath:      getFlag("grpc-web-root-path", ""),
-		PortForward:          getBoolFlag("port-forward"),
-		PortForwardNamespace: getFlag("port-forward-namespace", ""),
-	}, nil
+// Instance returns the named ArgoCD instance, the default instance if name is empty
+func (config *AppSourceConfig) Instance(name string) (*ArgoCDInstance, error) {
+	if name == "" {
+		name = defaultInstance
+	}
+	instance, ok := config.Instances[name]
+	if !ok {
+		return nil, fmt.Errorf("argocd instance %s is not configured", name)
+	}
+	return instance, nil
 }
 
-func (r *AppSourceReconciler) UpsertAppSourceConfig() (ok bool, err error) {
-	if err := r.UpsertConfigmap(); err != nil {
-		return true, err
+// parseTokenSecretRef parses the argocd.tokenSecretRef value, returning nil if none is configured
+func parseTokenSecretRef(data, defaultNamespace string) (*TokenSecretRef, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	ref := &TokenSecretRef{}
+	if err := yaml.Unmarshal([]byte(data), ref); err != nil {
+		return nil, err
 	}
-	if err := r.UpsertProjectProfiles(); err != nil {
-		return false, err
+	if ref.Name == "" || ref.Key == "" {
+		return nil, errors.New("argocd.tokenSecretRef requires a name and a key")
 	}
-	if err := r.UpsertArgoCDClients(); err != nil {
-		return false, err
+	if ref.Namespace == "" {
+		ref.Namespace = defaultNamespace
 	}
-	return true, nil
+	return ref, nil
 }
 
-//GetAppSourceConfigmapOrDie returns the AppSource ConfigMap defined by admins or crashes with error
-func (r *AppSourceReconciler) UpsertConfigmap() (err error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
-	overrides := clientcmd.ConfigOverrides{}
-	clientConfig := clientcmd.NewInteractiveDeferredLoadingClientConfig(loadingRules, &overrides, os.Stdin)
-	config, err := clientConfig.ClientConfig()
+// parseProjectProfiles parses the project.profiles value, compiles every profile name pattern and returns
+// the profiles in the order they are matched: by decreasing priority, then in configmap order. Profiles
+// sharing a list entry are ordered by name, as the entry is a map
+func parseProjectProfiles(data string) ([]*ProjectTemplate, error) {
+	var entries []map[string]*ProjectTemplate = []map[string]*ProjectTemplate{}
+	err := yaml.Unmarshal([]byte(data), &entries)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
+	profiles := []*ProjectTemplate{}
+	for _, entry := range entries {
+		names := make([]string, 0, len(entry))
+		for name := range entry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			project := entry[name]
+			if project == nil || (project.NamePattern == "" && project.NamespaceSelector == nil && len(project.NamespaceAnnotations) == 0) {
+				return nil, fmt.Errorf("profile %s has no namePattern, namespaceSelector or namespaceAnnotations", name)
+			}
+			project.Name = name
+			profiles = append(profiles, project)
+		}
 	}
-	//Get AppSource ConfigMap
-	r.ConfigMap, err = clientset.CoreV1().ConfigMaps("argocd-appsource").Get(context.TODO(), appSourceCM, metav1.GetOptions{})
-	if err != nil {
-		return err
+	seen := map[string]bool{}
+	for _, project := range profiles {
+		name := project.Name
+		if seen[name] {
+			return nil, fmt.Errorf("profile %s is defined more than once", name)
+		}
+		seen[name] = true
+		if err := parseNamespaceMatchers(name, project); err != nil {
+			return nil, err
+		}
+		if err := validateProjectSpec(name, project.Spec); err != nil {
+			return nil, err
+		}
+		if project.ApplicationNameTemplate != "" {
+			project.NameTemplate, err = template.New(name).Option("missingkey=error").Parse(project.ApplicationNameTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s has an invalid applicationNameTemplate: %v", name, err)
+			}
+		}
+		if appTemplate := project.ApplicationTemplate; appTemplate != nil && appTemplate.AllowedSyncPolicy != nil {
+			switch appTemplate.AllowedSyncPolicy.Enforcement {
+			case "", SyncPolicyEnforcementReject, SyncPolicyEnforcementClamp:
+			default:
+				return nil, fmt.Errorf("profile %s has an invalid sync policy enforcement %s", name, appTemplate.AllowedSyncPolicy.Enforcement)
+			}
+		}
+		if err := parseDestinationTemplate(name, project.Destination); err != nil {
+			return nil, err
+		}
+		switch project.DeletionMode {
+		case "", appsource.DeletionModeOrphan, appsource.DeletionModeCascadeForeground, appsource.DeletionModeCascadeBackground:
+		default:
+			return nil, fmt.Errorf("profile %s has an invalid deletionMode %s", name, project.DeletionMode)
+		}
 	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Priority > profiles[j].Priority
+	})
+	return profiles, nil
+}
 
+// parseNamespaceMatchers compiles the namespace name pattern, label selector and project name template of
+// the profile. The project name comes from the template, or else from the namePattern capturing groups
+func parseNamespaceMatchers(name string, project *ProjectTemplate) (err error) {
+	if project.NamePattern != "" {
+		project.PatternCompiler, err = regexp.Compile(project.NamePattern)
+		if err != nil {
+			return fmt.Errorf("profile %s has an invalid namePattern: %v", name, err)
+		}
+	}
+	for _, pattern := range project.ExcludeNamePatterns {
+		exclude, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("profile %s has an invalid excludeNamePatterns entry: %v", name, err)
+		}
+		project.ExcludeCompilers = append(project.ExcludeCompilers, exclude)
+	}
+	if project.NamespaceSelector != nil {
+		project.Selector, err = metav1.LabelSelectorAsSelector(project.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("profile %s has an invalid namespaceSelector: %v", name, err)
+		}
+	}
+	if project.ProjectNameTemplate != "" {
+		project.ProjectNameCompiler, err = template.New(name).Option("missingkey=error").Parse(project.ProjectNameTemplate)
+		if err != nil {
+			return fmt.Errorf("profile %s has an invalid projectNameTemplate: %v", name, err)
+		}
+		return nil
+	}
+	if project.PatternCompiler == nil {
+		return fmt.Errorf("profile %s requires a projectNameTemplate when it has no namePattern", name)
+	}
+	if project.PatternCompiler.NumSubexp() == 0 {
+		return fmt.Errorf("profile %s namePattern %s has no capturing group for the project name", name, project.NamePattern)
+	}
 	return nil
 }
 
-func (r *AppSourceReconciler) UpsertArgoCDClients() error {
-	argocdClientOpts, err := r.GetClientOpts()
-	if err != nil {
-		return err
+// parseDestinationTemplate checks that a profile destination selects a cluster either by server or by name,
+// and parses its templates
+func parseDestinationTemplate(name string, destination *DestinationTemplate) (err error) {
+	if destination == nil {
+		return nil
+	}
+	if (destination.Server == "") == (destination.Name == "") {
+		return fmt.Errorf("profile %s destination requires either a server or a name", name)
+	}
+	if destination.Server != "" {
+		destination.ServerTemplate, err = template.New(name).Option("missingkey=error").Parse(destination.Server)
+	} else {
+		destination.NameTemplate, err = template.New(name).Option("missingkey=error").Parse(destination.Name)
 	}
-	argocdClient, err := argocdClientSet.NewClient(argocdClientOpts)
 	if err != nil {
-		return err
+		return fmt.Errorf("profile %s has an invalid destination: %v", name, err)
 	}
+	return nil
+}
+
+// validateProjectSpec checks that a profile AppProject spec is well formed. Role policies
+// are not validated since they reference the project name, which is only known per namespace
+func validateProjectSpec(name string, spec *argocd.AppProjectSpec) error {
+	if spec == nil {
+		return fmt.Errorf("profile %s has no spec", name)
+	}
+	project := argocd.AppProject{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       *spec.DeepCopy(),
+	}
+	for i := range project.Spec.Roles {
+		project.Spec.Roles[i].Policies = nil
+	}
+	if err := project.ValidateProject(); err != nil {
+		return fmt.Errorf("profile %s has an invalid spec: %v", name, err)
+	}
+	return nil
+}
 
-	r.Clients.Applications.Closer, r.Clients.Applications.Client, err = argocdClient.NewApplicationClient()
+// GetAuthToken returns the ArgoCD API token stored in the Secret referenced by the
+// ArgoCD instance, falling back to the ARGOCD_TOKEN environment variable. The Secret is read
+// through an informer watching only that Secret, so Secrets are not cached cluster-wide
+func (r *AppSourceReconciler) GetAuthToken(ctx context.Context, instance *ArgoCDInstance) (string, error) {
+	ref := instance.TokenSecretRef
+	if ref == nil {
+		return os.Getenv("ARGOCD_TOKEN"), nil
+	}
+
+	secret := v1.Secret{}
+	if err := r.TokenSecrets.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		return "", err
+	}
+	token, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// GetClientOpts returns a ArgoCD ClientOpts object with any fields
+// found in the ArgoCD instance config
+func (r *AppSourceReconciler) GetClientOpts(ctx context.Context, instance *ArgoCDInstance) (*argocdClientSet.ClientOptions, error) {
+	token, err := r.GetAuthToken(ctx, instance)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	r.Clients.Projects.Closer, r.Clients.Projects.Client, err = argocdClient.NewProjectClient()
+
+	flags := instance.ClientOpts
+	return &argocdClientSet.ClientOptions{
+		ServerAddr:           instance.ServerAddr,
+		AuthToken:            token,
+		PlainText:            flags.getBool("plaintext"),
+		Insecure:             flags.getBool("insecure"),
+		CertFile:             flags.get("server-crt", ""),
+		ClientCertFile:       flags.get("client-crt", ""),
+		ClientCertKeyFile:    flags.get("client-crt-key", ""),
+		GRPCWeb:              flags.getBool("grpc-web"),
+		GRPCWebRootPath:      flags.get("grpc-web-root-path", ""),
+		PortForward:          flags.getBool("port-forward"),
+		PortForwardNamespace: flags.get("port-forward-namespace", ""),
+	}, nil
+}
+
+// UpsertArgoCDClients returns the shared ArgoCD clients of the ArgoCD instance,
+// reconnecting them if the server address, client options or token changed
+func (r *AppSourceReconciler) UpsertArgoCDClients(ctx context.Context, instance *ArgoCDInstance) (*ArgoCDClients, error) {
+	argocdClientOpts, err := r.GetClientOpts(ctx, instance)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return r.ArgoCD.Clients(instance.Name, argocdClientOpts)
+}
+
+// FindProject returns the first profile matching the namespace, bound to the namespace labels and annotations
+func (config *AppSourceConfig) FindProject(namespace *v1.Namespace) (*ProjectTemplate, error) {
+	for _, project := range config.ProjectProfiles {
+		if project.matches(namespace) {
+			bound := *project
+			bound.namespaceLabels = namespace.Labels
+			bound.namespaceAnnotations = namespace.Annotations
+			return &bound, nil
+		}
+	}
+	return nil, errors.New("unable to get project spec from profiles")
 }
 
-func (r *AppSourceReconciler) UpsertProjectProfiles() error {
-	var profiles []map[string]*ProjectTemplate = []map[string]*ProjectTemplate{}
-	err := yaml.Unmarshal([]byte(r.ConfigMap.Data["project.profiles"]), &profiles)
+// ExplainProfile returns the profile matched by the AppSource namespace along with its namePattern capturing groups
+// and the resolved project name, or nil if the namespace matches no profile
+func (config *AppSourceConfig) ExplainProfile(appSource *appsource.AppSource, namespace *v1.Namespace) *appsource.AppSourceProfileMatch {
+	proj, err := config.FindProject(namespace)
 	if err != nil {
-		return err
+		return nil
 	}
-	r.ProjectProfiles = profiles
-	r.UpsertCompilers()
-	return nil
+	explanation := &appsource.AppSourceProfileMatch{Name: proj.Name}
+	if groups := proj.templateData(appSource, "").Groups; len(groups) > 0 {
+		explanation.Groups = groups
+	}
+	// Project is left empty when it cannot be resolved, the error is reported by ValidateAppSource
+	explanation.Project, _ = proj.GetProjectName(appSource)
+	return explanation
 }
 
-func (r *AppSourceReconciler) UpsertCompilers() {
-	for i, profile := range r.ProjectProfiles {
-		for name, project := range profile {
-			if project.NamePattern != "" {
-				r.ProjectProfiles[i][name].PatternCompiler = regexp.MustCompile(project.NamePattern)
-			}
+// matches returns true if the namespace matches the profile namePattern, namespaceSelector and namespaceAnnotations,
+// and none of its excludeNamePatterns
+func (proj *ProjectTemplate) matches(namespace *v1.Namespace) bool {
+	for _, exclude := range proj.ExcludeCompilers {
+		if exclude.MatchString(namespace.Name) {
+			return false
+		}
+	}
+	if proj.PatternCompiler != nil && !proj.PatternCompiler.MatchString(namespace.Name) {
+		return false
+	}
+	if proj.Selector != nil && !proj.Selector.Matches(labels.Set(namespace.Labels)) {
+		return false
+	}
+	for key, pattern := range proj.NamespaceAnnotations {
+		value, ok := namespace.Annotations[key]
+		if !ok || !glob.Match(pattern, value) {
+			return false
 		}
 	}
+	return true
 }
 
-func (r *AppSourceReconciler) FindProject(projectName string) (*ProjectTemplate, error) {
-	for _, profiles := range r.ProjectProfiles {
-		for _, project := range profiles {
-			if project.PatternCompiler.Match([]byte(projectName)) {
-				return project, nil
-			}
+// ValidateAppSource checks that the AppSource namespace matches a project profile and that
+// the AppSource repository is permitted by it, returning the matching profile
+func (config *AppSourceConfig) ValidateAppSource(appSource *appsource.AppSource, namespace *v1.Namespace) (*ProjectTemplate, error) {
+	proj, err := config.FindProject(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("namespace %s does not match any project profile", appSource.Namespace)
+	}
+	projectName, err := proj.GetProjectName(appSource)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get project name from namespace %s: %v", appSource.Namespace, err)
+	}
+	project := argocd.AppProject{ObjectMeta: metav1.ObjectMeta{Name: projectName}}
+	if proj.Spec != nil {
+		project.Spec = *proj.Spec
+	}
+	if !project.IsSourcePermitted(appSource.Spec.ApplicationSource) {
+		return nil, fmt.Errorf("repository %s is not permitted in project %s, permitted repositories are: %s",
+			appSource.Spec.RepoURL, projectName, strings.Join(project.Spec.SourceRepos, ", "))
+	}
+	if proj.Destination != nil {
+		if _, err := proj.GetDestination(appSource, ""); err != nil {
+			return nil, err
 		}
 	}
-	return nil, errors.New("unable to get project spec from profiles")
+	if denied := proj.deniedSyncPolicy(appSource); len(denied) > 0 &&
+		(proj.ApplicationTemplate == nil || !proj.ApplicationTemplate.AllowedSyncPolicy.clamps()) {
+		return nil, fmt.Errorf("sync policy settings not allowed in project %s: %s", projectName, strings.Join(denied, ", "))
+	}
+	return proj, nil
 }
 
+// GetProjectName returns the ArgoCD project name of the AppSource, rendered from the profile projectNameTemplate
+// or captured by the namePattern
 func (proj *ProjectTemplate) GetProjectName(appSource *appsource.AppSource) (result string, err error) {
+	if proj.ProjectNameCompiler != nil {
+		name := strings.Builder{}
+		if err := proj.ProjectNameCompiler.Execute(&name, proj.templateData(appSource, "")); err != nil {
+			return "", fmt.Errorf("unable to render projectNameTemplate: %v", err)
+		}
+		if errs := validation.IsDNS1123Subdomain(name.String()); len(errs) > 0 {
+			return "", fmt.Errorf("invalid project name %s: %s", name.String(), strings.Join(errs, ", "))
+		}
+		return name.String(), nil
+	}
 	matches := proj.PatternCompiler.FindStringSubmatch(appSource.Namespace)
 	if len(matches) < 2 {
 		// Project name could not be extracted
@@ -211,3 +638,185 @@ func (proj *ProjectTemplate) GetProjectName(appSource *appsource.AppSource) (res
 	}
 	return match, nil
 }
+
+// GetApplicationName returns the ArgoCD Application name of the AppSource, rendered from the profile
+// applicationNameTemplate or the AppSource name if the profile has none
+func (proj *ProjectTemplate) GetApplicationName(appSource *appsource.AppSource) (string, error) {
+	if proj.NameTemplate == nil {
+		return appSource.Name, nil
+	}
+	name, err := proj.render(proj.NameTemplate, appSource)
+	if err != nil {
+		return "", fmt.Errorf("unable to render applicationNameTemplate: %v", err)
+	}
+	return fitApplicationName(name)
+}
+
+// GetDestination returns the ArgoCD Application destination of the AppSource: its namespace on the cluster
+// rendered from the profile destination, or on defaultServer if the profile has none
+func (proj *ProjectTemplate) GetDestination(appSource *appsource.AppSource, defaultServer string) (argocd.ApplicationDestination, error) {
+	destination := argocd.ApplicationDestination{Server: defaultServer, Namespace: appSource.Namespace}
+	if proj == nil || proj.Destination == nil {
+		return destination, nil
+	}
+	var err error
+	if proj.Destination.ServerTemplate != nil {
+		destination.Server, err = proj.render(proj.Destination.ServerTemplate, appSource)
+	} else {
+		destination.Server = ""
+		destination.Name, err = proj.render(proj.Destination.NameTemplate, appSource)
+	}
+	if err != nil {
+		return destination, fmt.Errorf("unable to render destination: %v", err)
+	}
+	if destination.Server == "" && destination.Name == "" {
+		return destination, fmt.Errorf("destination of profile %s renders to an empty cluster", proj.Name)
+	}
+	return destination, nil
+}
+
+// render executes a profile template with the AppSource namespace and name, the ArgoCD project name
+// and the namePattern capturing groups
+func (proj *ProjectTemplate) render(tmpl *template.Template, appSource *appsource.AppSource) (string, error) {
+	projectName, err := proj.GetProjectName(appSource)
+	if err != nil {
+		return "", err
+	}
+	result := strings.Builder{}
+	if err := tmpl.Execute(&result, proj.templateData(appSource, projectName)); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// templateData returns the data given to profile templates
+func (proj *ProjectTemplate) templateData(appSource *appsource.AppSource, projectName string) ApplicationNameData {
+	data := ApplicationNameData{
+		Namespace:   appSource.Namespace,
+		Name:        appSource.Name,
+		Project:     projectName,
+		Groups:      map[string]string{},
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+	for key, value := range proj.namespaceLabels {
+		data.Labels[key] = value
+	}
+	for key, value := range proj.namespaceAnnotations {
+		data.Annotations[key] = value
+	}
+	if proj.PatternCompiler == nil {
+		return data
+	}
+	matches := proj.PatternCompiler.FindStringSubmatch(appSource.Namespace)
+	if matches == nil {
+		return data
+	}
+	for i, group := range proj.PatternCompiler.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		data.Groups[strconv.Itoa(i)] = matches[i]
+		if group != "" {
+			data.Groups[group] = matches[i]
+		}
+	}
+	return data
+}
+
+// fitApplicationName shortens names longer than a DNS-1123 label, ArgoCD uses the Application name as a label
+// value on its resources. The end of the name is replaced with a hash of the full name to keep it unique
+func fitApplicationName(name string) (string, error) {
+	if len(name) > validation.DNS1123LabelMaxLength {
+		hash := sha256.Sum256([]byte(name))
+		suffix := hex.EncodeToString(hash[:])[:8]
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)-1], "-.") + "-" + suffix
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid application name %s: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// resolveSyncPolicy returns the Application sync policy: the profile default extended with the
+// settings requested by the AppSource which the profile allows. Requested settings which are
+// not allowed are returned separately
+func (proj *ProjectTemplate) resolveSyncPolicy(appSource *appsource.AppSource) (*argocd.SyncPolicy, []string) {
+	var policy *argocd.SyncPolicy
+	var allowed *AllowedSyncPolicy
+	if proj.ApplicationTemplate != nil {
+		policy = proj.ApplicationTemplate.SyncPolicy.DeepCopy()
+		allowed = proj.ApplicationTemplate.AllowedSyncPolicy
+	}
+	requested := appSource.Spec.SyncPolicy
+	if requested == nil {
+		return policy, nil
+	}
+	if allowed == nil {
+		allowed = &AllowedSyncPolicy{}
+	}
+	if policy == nil {
+		policy = &argocd.SyncPolicy{}
+	}
+
+	var denied []string
+	if automated := requested.Automated; automated != nil {
+		if !allowed.Automated {
+			denied = append(denied, "automated")
+		} else {
+			if policy.Automated == nil {
+				policy.Automated = &argocd.SyncPolicyAutomated{}
+			}
+			if automated.Prune && !allowed.Prune {
+				denied = append(denied, "automated.prune")
+			} else if automated.Prune {
+				policy.Automated.Prune = true
+			}
+			if automated.SelfHeal && !allowed.SelfHeal {
+				denied = append(denied, "automated.selfHeal")
+			} else if automated.SelfHeal {
+				policy.Automated.SelfHeal = true
+			}
+			if automated.AllowEmpty && !allowed.AllowEmpty {
+				denied = append(denied, "automated.allowEmpty")
+			} else if automated.AllowEmpty {
+				policy.Automated.AllowEmpty = true
+			}
+		}
+	}
+	for _, option := range requested.SyncOptions {
+		if !globMatchAny(allowed.SyncOptions, option) {
+			denied = append(denied, "syncOptions "+option)
+		} else if !policy.SyncOptions.HasOption(option) {
+			policy.SyncOptions = append(policy.SyncOptions, option)
+		}
+	}
+	if requested.Retry != nil {
+		if !allowed.Retry {
+			denied = append(denied, "retry")
+		} else {
+			policy.Retry = requested.Retry.DeepCopy()
+		}
+	}
+
+	if policy.IsZero() {
+		policy = nil
+	}
+	return policy, denied
+}
+
+// deniedSyncPolicy returns the sync policy settings requested by the AppSource which the profile does not allow
+func (proj *ProjectTemplate) deniedSyncPolicy(appSource *appsource.AppSource) []string {
+	_, denied := proj.resolveSyncPolicy(appSource)
+	return denied
+}
+
+// globMatchAny returns true if value matches any of the glob patterns
+func globMatchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if glob.Match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ConfigStore serves the parsed AppSource configmap. The configmap is read through an
+// informer watching only the configmap, and is only parsed again when it changes
+type ConfigStore struct {
+	// Reader used to get the configmap, expected to be informer-backed
+	Reader client.Reader
+	// Location of the AppSource configmap
+	ConfigMap types.NamespacedName
+	// Namespace of Secrets referenced without a namespace
+	DefaultNamespace string
+
+	lock          sync.RWMutex
+	config        *AppSourceConfig
+	failedVersion string
+}
+
+// NewConfigStore returns a ConfigStore reading the AppSource configmap
+func NewConfigStore(reader client.Reader, configMap types.NamespacedName, defaultNamespace string) *ConfigStore {
+	return &ConfigStore{
+		Reader:           reader,
+		ConfigMap:        configMap,
+		DefaultNamespace: defaultNamespace,
+	}
+}
+
+// Get returns the last successfully parsed config, or nil if none was loaded yet
+func (s *ConfigStore) Get() *AppSourceConfig {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.config
+}
+
+// Load returns the parsed AppSource config, parsing the configmap again only if its
+// resource version changed. An invalid configmap does not replace a previously valid config
+func (s *ConfigStore) Load(ctx context.Context) (*AppSourceConfig, error) {
+	configMap := v1.ConfigMap{}
+	if err := s.Reader.Get(ctx, s.ConfigMap, &configMap); err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	config, failedVersion := s.config, s.failedVersion
+	s.lock.RUnlock()
+	if config != nil && (config.ResourceVersion == configMap.ResourceVersion || failedVersion == configMap.ResourceVersion) {
+		return config, nil
+	}
+	return s.Update(ctx, &configMap)
+}
+
+// Update parses the given configmap and atomically swaps it in as the current config
+func (s *ConfigStore) Update(ctx context.Context, configMap *v1.ConfigMap) (*AppSourceConfig, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.config != nil && s.config.ResourceVersion == configMap.ResourceVersion {
+		// Parsed concurrently by another reconcile
+		return s.config, nil
+	}
+
+	config, err := ParseAppSourceConfig(configMap, s.DefaultNamespace)
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		if s.config == nil {
+			return nil, err
+		}
+		// Keep serving the last valid config
+		s.failedVersion = configMap.ResourceVersion
+		log.FromContext(ctx).Error(err, "invalid AppSource configmap, keeping previous config",
+			"configmap", s.ConfigMap, "resourceVersion", s.config.ResourceVersion)
+		return s.config, nil
+	}
+	configReloads.WithLabelValues("success").Inc()
+	s.config = config
+	s.failedVersion = ""
+	return config, nil
+}
+
+// IsConfigMap returns true if obj is the AppSource configmap
+func (s *ConfigStore) IsConfigMap(obj client.Object) bool {
+	return obj.GetNamespace() == s.ConfigMap.Namespace && obj.GetName() == s.ConfigMap.Name
+}
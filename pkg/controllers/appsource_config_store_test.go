@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("AppSource config store", func() {
+	var (
+		ctx   context.Context
+		store *ConfigStore
+	)
+	key := types.NamespacedName{Namespace: "argocd", Name: DefaultConfigMapName}
+
+	// newConfigMap returns the AppSource configmap at resourceVersion with a single profile described as description
+	newConfigMap := func(resourceVersion, description string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name, ResourceVersion: resourceVersion},
+			Data: map[string]string{
+				"argocd.address": "argocd-server.argocd.svc:443",
+				"project.profiles": `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    spec:
+      description: ` + description + `
+      sourceRepos: ['*']
+`,
+			},
+		}
+	}
+	// invalidConfigMap returns an AppSource configmap at resourceVersion whose profiles cannot be parsed
+	invalidConfigMap := func(resourceVersion string) *v1.ConfigMap {
+		configMap := newConfigMap(resourceVersion, "")
+		configMap.Data["project.profiles"] = "- my-project:\n    namePattern: (\n"
+		return configMap
+	}
+	description := func(config *AppSourceConfig) string {
+		return config.ProjectProfiles[0].Spec.Description
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = NewConfigStore(fake.NewClientBuilder().Build(), key, "argocd")
+	})
+
+	It("parses the first configmap", func() {
+		config, err := store.Update(ctx, newConfigMap("1", "first"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(description(config)).To(Equal("first"))
+		Expect(store.Get()).To(BeIdenticalTo(config))
+	})
+
+	It("reports an invalid configmap when no config was loaded yet", func() {
+		_, err := store.Update(ctx, invalidConfigMap("1"))
+		Expect(err).To(HaveOccurred())
+		Expect(store.Get()).To(BeNil())
+	})
+
+	It("keeps the last valid config when the configmap becomes invalid", func() {
+		_, err := store.Update(ctx, newConfigMap("1", "first"))
+		Expect(err).NotTo(HaveOccurred())
+		failures := testutil.ToFloat64(configReloads.WithLabelValues("failure"))
+
+		config, err := store.Update(ctx, invalidConfigMap("2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(description(config)).To(Equal("first"))
+		Expect(description(store.Get())).To(Equal("first"))
+		Expect(testutil.ToFloat64(configReloads.WithLabelValues("failure"))).To(Equal(failures + 1))
+
+		// A later valid configmap replaces it
+		config, err = store.Update(ctx, newConfigMap("3", "third"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(description(config)).To(Equal("third"))
+	})
+
+	It("only parses the configmap again when its resource version changes", func() {
+		first, err := store.Update(ctx, newConfigMap("1", "first"))
+		Expect(err).NotTo(HaveOccurred())
+		config, err := store.Update(ctx, newConfigMap("1", "ignored"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(BeIdenticalTo(first))
+	})
+
+	It("loads the configmap through its reader", func() {
+		store.Reader = fake.NewClientBuilder().WithObjects(newConfigMap("", "loaded")).Build()
+		config, err := store.Load(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(description(config)).To(Equal("loaded"))
+	})
+})
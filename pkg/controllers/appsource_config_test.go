@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"strings"
+
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// parseProfiles parses an AppSource configmap holding the given project.profiles value
+func parseProfiles(profiles string) (*AppSourceConfig, error) {
+	return ParseAppSourceConfig(&v1.ConfigMap{Data: map[string]string{"project.profiles": profiles}}, appsource.ArgocdNamespace)
+}
+
+// findProfile parses the given project.profiles value and returns the profile matching the namespace,
+// failing the spec if the profiles are invalid or none matches
+func findProfile(profiles, namespace string) *ProjectTemplate {
+	config, err := parseProfiles(profiles)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	proj, err := config.FindProject(namespaceNamed(namespace))
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return proj
+}
+
+// namespaceNamed returns a namespace without labels or annotations
+func namespaceNamed(name string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+var _ = Describe("AppSource sync policy", func() {
+	var proj *ProjectTemplate
+	BeforeEach(func() {
+		proj = findProfile(`
+- dev:
+    namePattern: (?P<project>.*)-dev-.*
+    spec:
+      sourceRepos:
+      - '*'
+    applicationTemplate:
+      syncPolicy:
+        syncOptions:
+        - Validate=false
+`, "team-dev-1")
+	})
+	newAppSource := func(policy *argocd.SyncPolicy) *appsource.AppSource {
+		return &appsource.AppSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "team-dev-1"},
+			Spec: appsource.AppSourceSpec{
+				ApplicationSource: argocd.ApplicationSource{RepoURL: "https://github.com/argoproj/argocd-example-apps"},
+				SyncPolicy:        policy,
+			},
+		}
+	}
+
+	It("merges allowed settings into the profile default", func() {
+		proj.ApplicationTemplate.AllowedSyncPolicy = &AllowedSyncPolicy{Automated: true, Prune: true, SyncOptions: []string{"CreateNamespace=*"}}
+		policy, denied := proj.resolveSyncPolicy(newAppSource(&argocd.SyncPolicy{
+			Automated:   &argocd.SyncPolicyAutomated{Prune: true},
+			SyncOptions: argocd.SyncOptions{"CreateNamespace=true"},
+		}))
+		Expect(denied).To(BeEmpty())
+		Expect(policy.Automated.Prune).To(BeTrue())
+		Expect(policy.SyncOptions).To(ConsistOf("Validate=false", "CreateNamespace=true"))
+	})
+
+	It("clamps settings which are not allowed", func() {
+		proj.ApplicationTemplate.AllowedSyncPolicy = &AllowedSyncPolicy{Automated: true, Enforcement: SyncPolicyEnforcementClamp}
+		appSource := newAppSource(&argocd.SyncPolicy{
+			Automated:   &argocd.SyncPolicyAutomated{Prune: true, SelfHeal: true},
+			SyncOptions: argocd.SyncOptions{"PrunePropagationPolicy=orphan"},
+		})
+		app := argocd.Application{}
+		applySyncPolicy(&app, appSource, proj)
+		Expect(app.Spec.SyncPolicy.Automated).To(Equal(&argocd.SyncPolicyAutomated{}))
+		Expect(app.Spec.SyncPolicy.SyncOptions).To(ConsistOf("Validate=false"))
+		Expect(appSource.Status.Conditions).To(HaveLen(1))
+		Expect(appSource.Status.Conditions[0].Type).To(Equal(appsource.SyncPolicyClamped))
+		Expect(appSource.Status.Conditions[0].Message).To(ContainSubstring("automated.prune, automated.selfHeal, syncOptions PrunePropagationPolicy=orphan"))
+
+		appSource.Spec.SyncPolicy = nil
+		applySyncPolicy(&app, appSource, proj)
+		Expect(appSource.Status.Conditions).To(BeEmpty())
+	})
+
+	It("rejects settings which are not allowed by default", func() {
+		config := &AppSourceConfig{ProjectProfiles: []*ProjectTemplate{proj}}
+		appSource := newAppSource(&argocd.SyncPolicy{Automated: &argocd.SyncPolicyAutomated{}})
+		_, err := config.ValidateAppSource(appSource, namespaceNamed(appSource.Namespace))
+		Expect(err).To(MatchError("sync policy settings not allowed in project team: automated"))
+	})
+
+	It("rejects requested settings when the profile has no applicationTemplate", func() {
+		proj.ApplicationTemplate = nil
+		config := &AppSourceConfig{ProjectProfiles: []*ProjectTemplate{proj}}
+		appSource := newAppSource(&argocd.SyncPolicy{Automated: &argocd.SyncPolicyAutomated{}})
+		_, err := config.ValidateAppSource(appSource, namespaceNamed(appSource.Namespace))
+		Expect(err).To(MatchError("sync policy settings not allowed in project team: automated"))
+	})
+})
+
+var _ = Describe("AppSource Application name", func() {
+	// profiles returns a profile naming Applications with nameTemplate
+	profiles := func(nameTemplate string) string {
+		return `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    applicationNameTemplate: '` + nameTemplate + `'
+    spec:
+      sourceRepos:
+      - '*'
+`
+	}
+	appSource := &appsource.AppSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "my-project-us-west-2"},
+	}
+
+	It("defaults to the AppSource name", func() {
+		Expect(applicationName(appSource, findProfile(profiles(""), appSource.Namespace))).To(Equal("api"))
+	})
+
+	It("renders the profile template", func() {
+		name, err := applicationName(appSource, findProfile(profiles(`{{ .Project }}-{{ index .Groups "2" }}-{{ .Name }}`), appSource.Namespace))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("my-project-west-api"))
+	})
+
+	It("keeps the name recorded in the status", func() {
+		created := appSource.DeepCopy()
+		created.Status.ApplicationName = "api"
+		Expect(applicationName(created, findProfile(profiles(`{{ .Namespace }}-{{ .Name }}`), appSource.Namespace))).To(Equal("api"))
+	})
+
+	It("hashes names longer than a DNS-1123 label", func() {
+		long := appSource.DeepCopy()
+		long.Name = strings.Repeat("a", 70)
+		name, err := applicationName(long, findProfile(profiles(`{{ .Namespace }}-{{ .Name }}`), appSource.Namespace))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(HaveLen(63))
+		Expect(name).To(HavePrefix("my-project-us-west-2-aaa"))
+
+		other := long.DeepCopy()
+		other.Name = strings.Repeat("a", 71)
+		Expect(applicationName(other, findProfile(profiles(`{{ .Namespace }}-{{ .Name }}`), appSource.Namespace))).NotTo(Equal(name))
+	})
+
+	It("rejects invalid templates", func() {
+		_, err := parseProfiles(profiles(`{{ .Name`))
+		Expect(err).To(MatchError(ContainSubstring("invalid applicationNameTemplate")))
+	})
+})
+
+var _ = Describe("Profile destinations", func() {
+	// profiles returns a profile with the given destination
+	profiles := func(destination string) string {
+		return `
+- my-project:
+    namePattern: (?P<project>.*)-us-(?P<region>west|east)-(\d.*)
+    destination:
+` + destination + `
+    spec:
+      sourceRepos:
+      - '*'
+`
+	}
+	appSource := &appsource.AppSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "my-project-us-west-2"},
+	}
+
+	It("defaults to the cluster host", func() {
+		Expect((*ProjectTemplate)(nil).GetDestination(appSource, appsource.ClusterServerName)).To(Equal(argocd.ApplicationDestination{
+			Server:    appsource.ClusterServerName,
+			Namespace: "my-project-us-west-2",
+		}))
+	})
+
+	It("renders the server from the namePattern groups", func() {
+		proj := findProfile(profiles(`      server: 'https://{{ .Groups.region }}.clusters.example.com'`), appSource.Namespace)
+		Expect(proj.GetDestination(appSource, appsource.ClusterServerName)).To(Equal(argocd.ApplicationDestination{
+			Server:    "https://west.clusters.example.com",
+			Namespace: "my-project-us-west-2",
+		}))
+	})
+
+	It("selects clusters by name", func() {
+		proj := findProfile(profiles(`      name: 'us-{{ .Groups.region }}'`), appSource.Namespace)
+		Expect(proj.GetDestination(appSource, appsource.ClusterServerName)).To(Equal(argocd.ApplicationDestination{
+			Name:      "us-west",
+			Namespace: "my-project-us-west-2",
+		}))
+	})
+
+	It("requires either a server or a name", func() {
+		_, err := parseProfiles(profiles(`      server: https://west.clusters.example.com
+      name: us-west`))
+		Expect(err).To(MatchError(ContainSubstring("either a server or a name")))
+		_, err = parseProfiles(profiles(`      server: '{{ .Groups'`))
+		Expect(err).To(MatchError(ContainSubstring("invalid destination")))
+	})
+
+	It("rejects AppSources whose destination does not render", func() {
+		proj := findProfile(profiles(`      name: '{{ .Groups.zone }}'`), appSource.Namespace)
+		_, err := proj.GetDestination(appSource, appsource.ClusterServerName)
+		Expect(err).To(MatchError(ContainSubstring("unable to render destination")))
+	})
+})
+
+var _ = Describe("ArgoCD instances", func() {
+	parse := func(instances, profileInstance string) (*AppSourceConfig, error) {
+		return ParseAppSourceConfig(&v1.ConfigMap{Data: map[string]string{
+			"argocd.address":   "argocd-server.argocd.svc:443",
+			"argocd.instances": instances,
+			"project.profiles": `
+- my-project:
+    namePattern: (.*)
+    argocdInstance: '` + profileInstance + `'
+    spec:
+      sourceRepos:
+      - '*'
+`,
+		}}, appsource.ArgocdNamespace)
+	}
+
+	It("parses named instances next to the default instance", func() {
+		config, err := parse(`
+payments:
+  address: argocd-server.payments.svc:443
+  clientOpts: --insecure
+  tokenSecretRef:
+    name: payments-token
+    key: token
+`, "payments")
+		Expect(err).NotTo(HaveOccurred())
+		instance, err := config.Instance("payments")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.ServerAddr).To(Equal("argocd-server.payments.svc:443"))
+		Expect(instance.ClientOpts.getBool("insecure")).To(BeTrue())
+		Expect(instance.TokenSecretRef).To(Equal(&TokenSecretRef{Name: "payments-token", Namespace: appsource.ArgocdNamespace, Key: "token"}))
+		instance, err = config.Instance("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.ServerAddr).To(Equal("argocd-server.argocd.svc:443"))
+	})
+
+	It("rejects profiles referencing unknown instances", func() {
+		_, err := parse("", "payments")
+		Expect(err).To(MatchError(ContainSubstring("unknown argocdInstance payments")))
+	})
+
+	It("rejects invalid instances", func() {
+		_, err := parse(`
+default:
+  address: argocd-server.payments.svc:443
+`, "")
+		Expect(err).To(MatchError(ContainSubstring("reserved")))
+		_, err = parse(`
+payments:
+  clientOpts: --insecure
+`, "")
+		Expect(err).To(MatchError(ContainSubstring("has no address")))
+	})
+})
+
+var _ = Describe("Namespace profile selection", func() {
+	newNamespace := func(labels, annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-api", Labels: labels, Annotations: annotations}}
+	}
+	appSource := &appsource.AppSource{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments-api"}}
+	profiles := `
+- prod:
+    namespaceSelector:
+      matchLabels:
+        env: prod
+      matchExpressions:
+      - key: tier
+        operator: In
+        values: [frontend, backend]
+    namespaceAnnotations:
+      provisioner.example.com/owner: 'team-*'
+    projectNameTemplate: '{{ .Labels.team }}-{{ .Labels.env }}'
+    spec:
+      sourceRepos:
+      - '*'
+- named:
+    namePattern: (?P<project>.*)-api
+    spec:
+      sourceRepos:
+      - '*'
+`
+
+	It("matches namespaces by labels and annotations", func() {
+		config, err := parseProfiles(profiles)
+		Expect(err).NotTo(HaveOccurred())
+		proj, err := config.FindProject(newNamespace(
+			map[string]string{"env": "prod", "tier": "backend", "team": "payments"},
+			map[string]string{"provisioner.example.com/owner": "team-payments"},
+		))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proj.Name).To(Equal("prod"))
+		Expect(proj.GetProjectName(appSource)).To(Equal("payments-prod"))
+	})
+
+	It("falls through to the next profile when a label or annotation does not match", func() {
+		config, err := parseProfiles(profiles)
+		Expect(err).NotTo(HaveOccurred())
+		for _, namespace := range []*v1.Namespace{
+			newNamespace(map[string]string{"env": "dev", "tier": "backend", "team": "payments"},
+				map[string]string{"provisioner.example.com/owner": "team-payments"}),
+			newNamespace(map[string]string{"env": "prod", "tier": "backend", "team": "payments"}, nil),
+		} {
+			proj, err := config.FindProject(namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proj.Name).To(Equal("named"))
+			Expect(proj.GetProjectName(appSource)).To(Equal("payments"))
+		}
+	})
+
+	It("reports missing template labels", func() {
+		config, err := parseProfiles(profiles)
+		Expect(err).NotTo(HaveOccurred())
+		proj, err := config.FindProject(newNamespace(
+			map[string]string{"env": "prod", "tier": "backend"},
+			map[string]string{"provisioner.example.com/owner": "team-payments"},
+		))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = proj.GetProjectName(appSource)
+		Expect(err).To(MatchError(ContainSubstring("unable to render projectNameTemplate")))
+	})
+
+	It("rejects profiles without a way to name the project", func() {
+		_, err := parseProfiles(`
+- prod:
+    namespaceSelector:
+      matchLabels:
+        env: prod
+    spec:
+      sourceRepos:
+      - '*'
+`)
+		Expect(err).To(MatchError(ContainSubstring("requires a projectNameTemplate")))
+		_, err = parseProfiles(`
+- prod:
+    spec:
+      sourceRepos:
+      - '*'
+`)
+		Expect(err).To(MatchError(ContainSubstring("has no namePattern, namespaceSelector or namespaceAnnotations")))
+	})
+})
+
+var _ = Describe("Profile precedence", func() {
+	names := func(config *AppSourceConfig) []string {
+		result := []string{}
+		for _, project := range config.ProjectProfiles {
+			result = append(result, project.Name)
+		}
+		return result
+	}
+	appSource := &appsource.AppSource{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments-dev"}}
+
+	It("orders profiles by priority, then configmap order, then name within an entry", func() {
+		config, err := parseProfiles(`
+- fallback:
+    namePattern: (.*)
+    spec: {sourceRepos: ['*']}
+- team:
+    namePattern: (?P<project>.*)-dev
+    spec: {sourceRepos: ['*']}
+    priority: 10
+  another:
+    namePattern: (?P<project>.*)-dev
+    spec: {sourceRepos: ['*']}
+    priority: 10
+- payments:
+    namePattern: (payments)-.*
+    spec: {sourceRepos: ['*']}
+    priority: 20
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(config)).To(Equal([]string{"payments", "another", "team", "fallback"}))
+
+		proj, err := config.FindProject(namespaceNamed("payments-dev"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proj.Name).To(Equal("payments"))
+	})
+
+	It("skips profiles excluding the namespace", func() {
+		config, err := parseProfiles(`
+- team:
+    namePattern: (?P<project>.*)-dev
+    spec: {sourceRepos: ['*']}
+    excludeNamePatterns:
+    - ^payments-
+    - ^kube-
+- fallback:
+    namePattern: (.*)
+    spec: {sourceRepos: ['*']}
+`)
+		Expect(err).NotTo(HaveOccurred())
+		proj, err := config.FindProject(namespaceNamed("payments-dev"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proj.Name).To(Equal("fallback"))
+		proj, err = config.FindProject(namespaceNamed("billing-dev"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proj.Name).To(Equal("team"))
+	})
+
+	It("explains the matched profile", func() {
+		config, err := parseProfiles(`
+- team:
+    namePattern: (?P<project>.*)-(dev|prod)
+    spec: {sourceRepos: ['*']}
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ExplainProfile(appSource, namespaceNamed("payments-dev"))).To(Equal(&appsource.AppSourceProfileMatch{
+			Name:    "team",
+			Groups:  map[string]string{"project": "payments", "1": "payments", "2": "dev"},
+			Project: "payments",
+		}))
+		Expect(config.ExplainProfile(appSource, namespaceNamed("payments"))).To(BeNil())
+	})
+
+	It("rejects duplicate profiles and invalid exclusions", func() {
+		_, err := parseProfiles(`
+- team:
+    namePattern: (.*)
+    spec: {sourceRepos: ['*']}
+- team:
+    namePattern: (.*)
+    spec: {sourceRepos: ['*']}
+`)
+		Expect(err).To(MatchError("profile team is defined more than once"))
+		_, err = parseProfiles(`
+- team:
+    namePattern: (.*)
+    spec: {sourceRepos: ['*']}
+    excludeNamePatterns:
+    - (
+`)
+		Expect(err).To(MatchError(ContainSubstring("profile team has an invalid excludeNamePatterns entry")))
+	})
+})
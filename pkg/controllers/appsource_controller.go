@@ -19,18 +19,29 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
 	projectTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
 )
@@ -54,17 +65,35 @@ type ArgoCDClients struct {
 type AppSourceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Uncached reader used by the kubernetes backend, defaults to the manager API reader
+	APIReader client.Reader
+	// Informers caching the Secrets holding ArgoCD API tokens, which changes reconnect the ArgoCD clients
+	TokenSecrets *ObjectInformers
 
 	// AppSource ConfigMap
-	ConfigMap *v1.ConfigMap
-	// ArgoCD Resource Clients
-	Clients ArgoCDClients
-	// ArgoCD Project Template
-	ProjectProfiles []map[string]*ProjectTemplate
-	// Server Address
+	Config *ConfigStore
+	// Informers caching the AppSource ConfigMap, which changes requeue every AppSource
+	ConfigMaps *ObjectInformers
+	// Location of the AppSource ConfigMap, used when Config is nil. Defaults to argocd-appsource-cm in the ArgoCD namespace
+	ConfigMap types.NamespacedName
+	// ArgoCD Resource Clients, shared across reconciles
+	ArgoCD *ClientManager
+	// Records events on AppSources
+	Recorder record.EventRecorder
+	// Server Address, the destination of Applications whose profile has none
 	ClusterHost string
 	// ArgoCD Namespace
 	ArgocdNS string
+	// Interval at which the ArgoCD Application status is mirrored into the AppSource,
+	// zero disables periodic refreshes
+	StatusRefreshInterval time.Duration
+	// Interval at which an Application deleted in cascade-foreground mode is checked until it is gone
+	DeletionPollInterval time.Duration
+	// Time to wait for a cascade-foreground deletion before reporting an error, zero waits forever
+	DeletionTimeout time.Duration
+
+	clientset         kubernetes.Interface
+	tokenSecretEvents chan event.GenericEvent
 }
 
 // Reconcile v1.0: Called upon AppSource creation, handles namespace validation and Project/App creation
@@ -80,37 +109,69 @@ func (r *AppSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	// This function checks if AppSource Status has changed, if so it updates the AppSource
 	// The function is defered in order to not always queue up new updates to the AppSource
-	defer func(conditionsBeforeReconcile []appsource.AppSourceCondition) {
-		if !appsource.IsEqual(appSource.Status.Conditions, conditionsBeforeReconcile) {
-			if ok := r.Status().Update(context.Background(), &appSource); ok != nil {
+	defer func(statusBeforeReconcile *appsource.AppSourceStatus) {
+		if !equality.Semantic.DeepEqual(appSource.Status, *statusBeforeReconcile) {
+			// AppSources whose finalizer was just released may already be gone
+			if ok := client.IgnoreNotFound(r.Status().Update(context.Background(), &appSource)); ok != nil {
 				// Change the error being returned
 				err = ok
 			}
 		}
-	}(appSource.Status.Conditions)
+	}(appSource.Status.DeepCopy())
 
-	if ok, err := r.UpsertAppSourceConfig(); err != nil {
-		if ok {
+	config, err := r.Config.Load(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
 			return ctrl.Result{Requeue: true}, errors.New("appsource configmap not created yet")
 		}
 		return ctrl.Result{}, err
-	} else {
-		defer r.Clients.Projects.Closer.Close()
-		defer r.Clients.Applications.Closer.Close()
 	}
 
 	if !appSource.ObjectMeta.DeletionTimestamp.IsZero() {
-		if err := r.ResolveFinalizers(ctx, &appSource); err != nil {
+		// Profile is only needed to prune the project, deletion proceeds without one
+		namespace := &v1.Namespace{}
+		if err := r.Get(ctx, types.NamespacedName{Name: appSource.Namespace}, namespace); err != nil {
+			namespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: appSource.Namespace}}
+		}
+		proj, _ := config.FindProject(namespace)
+		// The Application is deleted from the ArgoCD instance it was created in
+		backend, err := r.argoCDBackend(ctx, config, argocdInstanceName(&appSource, proj))
+		if err != nil {
+			r.recordCondition(&appSource, appsource.AppSourceCondition{
+				Type:       appsource.ApplicationDeletionError,
+				Message:    err.Error(),
+				Status:     appsource.ConditionFalse,
+				ObservedAt: metav1.Now(),
+			})
 			return ctrl.Result{}, err
-		} else {
-			return ctrl.Result{}, nil
 		}
+		return r.ResolveFinalizers(ctx, backend, &appSource, proj)
+	}
+
+	// Make sure the Application is deleted along with the AppSource
+	if err = r.ensureFinalizer(ctx, &appSource); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// Create the Application if necessary
-	proj, err := r.FindProject(req.Namespace)
+	// Profiles are matched against the namespace name, labels and annotations
+	namespace := &v1.Namespace{}
+	if err = r.Get(ctx, types.NamespacedName{Name: appSource.Namespace}, namespace); err != nil {
+		r.recordCondition(&appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationUnknownError,
+			Message:    fmt.Sprintf("unable to get namespace %s: %v", appSource.Namespace, err),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return ctrl.Result{}, err
+	}
+
+	// Record the matched profile so mismatches can be debugged from the AppSource status
+	appSource.Status.Profile = config.ExplainProfile(&appSource, namespace)
+
+	// Create the Application if necessary, the AppSource must be permitted by its project profile
+	proj, err := config.ValidateAppSource(&appSource, namespace)
 	if err != nil {
-		appSource.UpsertConditions(appsource.AppSourceCondition{
+		r.recordCondition(&appSource, appsource.AppSourceCondition{
 			Type:       appsource.ApplicationInvalidSpecError,
 			Message:    err.Error(),
 			Status:     appsource.ConditionFalse,
@@ -118,22 +179,163 @@ func (r *AppSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		})
 		return ctrl.Result{}, err
 	}
+	profileMatches.WithLabelValues(proj.Name).Inc()
+
+	backend, err := r.argoCDBackend(ctx, config, argocdInstanceName(&appSource, proj))
+	if err != nil {
+		r.recordCondition(&appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationUnknownError,
+			Message:    err.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return ctrl.Result{}, err
+	}
 
-	err = r.validateProject(ctx, &appSource, proj)
+	err = r.validateProject(ctx, backend, &appSource, proj)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	err = r.validateApplication(ctx, &appSource, proj)
+	err = r.validateApplication(ctx, backend, &appSource, proj)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// Errors preventing the reconcile are resolved once it succeeds
+	appSource.RemoveCondition(appsource.ApplicationUnknownError)
+
+	// Requeue periodically so the mirrored ArgoCD Application status stays fresh
+	return ctrl.Result{RequeueAfter: r.StatusRefreshInterval}, nil
+}
+
+// configMapToAppSources requeues every AppSource when the AppSource configmap changes,
+// so new profiles take effect without waiting for the next AppSource event
+func (r *AppSourceReconciler) configMapToAppSources(obj client.Object) []reconcile.Request {
+	if !r.Config.IsConfigMap(obj) {
+		return nil
+	}
+	return r.allAppSourceRequests()
+}
+
+// namespaceToAppSources requeues the AppSources of a namespace when it changes,
+// so profiles selecting namespaces by labels or annotations are matched again
+func (r *AppSourceReconciler) namespaceToAppSources(obj client.Object) []reconcile.Request {
+	return r.allAppSourceRequests(client.InNamespace(obj.GetName()))
+}
+
+// tokenSecretChanged reconnects the ArgoCD instances whose API token is stored in the Secret and requeues
+// every AppSource. A pending requeue already covers every AppSource, so further changes are dropped until it is handled
+func (r *AppSourceReconciler) tokenSecretChanged(secret client.Object) {
+	if config := r.Config.Get(); config != nil {
+		for _, instance := range config.Instances {
+			ref := instance.TokenSecretRef
+			if ref != nil && ref.Namespace == secret.GetNamespace() && ref.Name == secret.GetName() {
+				r.ArgoCD.Invalidate(instance.Name)
+			}
+		}
+	}
+	select {
+	case r.tokenSecretEvents <- event.GenericEvent{Object: secret}:
+	default:
+	}
+}
+
+// tokenSecretToAppSources requeues every AppSource when an API token Secret changes
+func (r *AppSourceReconciler) tokenSecretToAppSources(_ client.Object) []reconcile.Request {
+	return r.allAppSourceRequests()
+}
+
+// allAppSourceRequests returns a reconcile request for every AppSource in the cluster, or listed with opts
+func (r *AppSourceReconciler) allAppSourceRequests(opts ...client.ListOption) []reconcile.Request {
+	appSources := appsource.AppSourceList{}
+	if err := r.List(context.Background(), &appSources, opts...); err != nil {
+		ctrl.Log.WithName("controllers").WithName("AppSource").Error(err, "unable to list AppSources")
+		return nil
+	}
+	requests := make([]reconcile.Request, len(appSources.Items))
+	for i, appSource := range appSources.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: appSource.Namespace,
+			Name:      appSource.Name,
+		}}
+	}
+	return requests
+}
+
+// configMap returns the location of the AppSource configmap
+func (r *AppSourceReconciler) configMap() types.NamespacedName {
+	if r.ConfigMap.Name == "" {
+		return types.NamespacedName{Namespace: r.ArgocdNS, Name: DefaultConfigMapName}
+	}
+	return r.ConfigMap
+}
+
+// kubernetesClientset returns the clientset of the manager cluster shared by the object informers
+func (r *AppSourceReconciler) kubernetesClientset(mgr ctrl.Manager) (kubernetes.Interface, error) {
+	if r.clientset == nil {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return nil, err
+		}
+		r.clientset = clientset
+	}
+	return r.clientset, nil
+}
+
+// setupConfig reads the AppSource configmap through a dedicated informer, so the manager
+// does not cache every configmap of the cluster
+func (r *AppSourceReconciler) setupConfig(mgr ctrl.Manager) error {
+	if r.ConfigMaps == nil {
+		clientset, err := r.kubernetesClientset(mgr)
+		if err != nil {
+			return err
+		}
+		r.ConfigMaps = NewConfigMapInformers(clientset)
+		if err := mgr.Add(r.ConfigMaps); err != nil {
+			return err
+		}
+	}
+	if r.Config == nil {
+		r.Config = NewConfigStore(r.ConfigMaps, r.configMap(), r.ArgocdNS)
+	}
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AppSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := r.setupConfig(mgr); err != nil {
+		return err
+	}
+	if r.ArgoCD == nil {
+		r.ArgoCD = &ClientManager{}
+	}
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+	// Only the referenced token Secrets are watched, rather than every Secret of the cluster
+	if r.TokenSecrets == nil {
+		clientset, err := r.kubernetesClientset(mgr)
+		if err != nil {
+			return err
+		}
+		r.TokenSecrets = NewSecretInformers(clientset)
+		if err := mgr.Add(r.TokenSecrets); err != nil {
+			return err
+		}
+	}
+	r.tokenSecretEvents = make(chan event.GenericEvent, 1)
+	r.TokenSecrets.OnChange = r.tokenSecretChanged
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("argocd-appsource-controller")
+	}
+	if r.DeletionPollInterval == 0 {
+		r.DeletionPollInterval = 10 * time.Second
+	}
+	appSourceConditions.setReader(mgr.GetClient())
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appsource.AppSource{}).
+		Watches(&source.Informer{Informer: r.ConfigMaps.Informer(r.Config.ConfigMap)}, handler.EnqueueRequestsFromMapFunc(r.configMapToAppSources)).
+		Watches(&source.Kind{Type: &v1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceToAppSources)).
+		Watches(&source.Channel{Source: r.tokenSecretEvents}, handler.EnqueueRequestsFromMapFunc(r.tokenSecretToAppSources)).
 		Complete(r)
 }
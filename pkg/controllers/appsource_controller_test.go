@@ -0,0 +1,700 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	argocdClientSet "github.com/argoproj/argo-cd/v2/pkg/apiclient"
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource reconciler", func() {
+	const namespace = "my-project-us-west-2"
+	var (
+		ctx      context.Context
+		argoCD   *fakeArgoCD
+		recorder *record.FakeRecorder
+		r        *AppSourceReconciler
+	)
+	destination := argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: namespace}
+
+	// newReconciler returns a reconciler backed by a fake Kubernetes client holding objects and the AppSource configmap
+	newReconciler := func(objects ...client.Object) {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(appsource.AddToScheme(scheme)).To(Succeed())
+		Expect(argocd.AddToScheme(scheme)).To(Succeed())
+		configMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName},
+			Data: map[string]string{
+				"argocd.address": "argocd-server.argocd.svc:443",
+				"project.profiles": `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    spec:
+      description: Team project
+      sourceRepos:
+      - 'https://github.com/argoproj/*'
+`,
+			},
+		}
+		appSourceNamespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{"team": "guestbook"}}}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objects, configMap, appSourceNamespace)...).Build()
+		recorder = record.NewFakeRecorder(100)
+		r = &AppSourceReconciler{
+			Client:               k8s,
+			Scheme:               scheme,
+			APIReader:            k8s,
+			Config:               NewConfigStore(k8s, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, appsource.ArgocdNamespace),
+			ArgoCD:               &ClientManager{NewClients: argoCD.newClients},
+			Recorder:             recorder,
+			ClusterHost:          appsource.ClusterServerName,
+			ArgocdNS:             appsource.ArgocdNamespace,
+			DeletionPollInterval: time.Second,
+		}
+	}
+	newAppSource := func() *appsource.AppSource {
+		return &appsource.AppSource{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "guestbook", UID: "1234"},
+			Spec: appsource.AppSourceSpec{ApplicationSource: argocd.ApplicationSource{
+				RepoURL: "https://github.com/argoproj/argocd-example-apps",
+				Path:    "guestbook",
+			}},
+		}
+	}
+	// newDeletedAppSource returns an AppSource being deleted, along with its Application
+	newDeletedAppSource := func(mode appsource.DeletionMode) *appsource.AppSource {
+		appSource := newAppSource()
+		appSource.Finalizers = []string{applicationFinalizer}
+		appSource.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		appSource.Spec.DeletionMode = mode
+		app := &argocd.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: appsource.ArgocdNamespace},
+			Spec:       argocd.ApplicationSpec{Project: "my-project", Destination: destination},
+		}
+		setApplicationOwner(app, appSource)
+		argoCD.applications["guestbook"] = app
+		return appSource
+	}
+	reconcile := func() (ctrl.Result, error) {
+		return r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "guestbook"}})
+	}
+	getAppSource := func() *appsource.AppSource {
+		appSource := &appsource.AppSource{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "guestbook"}, appSource)).To(Succeed())
+		return appSource
+	}
+	conditionOf := func(appSource *appsource.AppSource, conditionType string) *appsource.AppSourceCondition {
+		for i := range appSource.Status.Conditions {
+			if appSource.Status.Conditions[i].Type == conditionType {
+				return &appSource.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		argoCD = newFakeArgoCD()
+	})
+
+	It("creates the project and the Application", func() {
+		newReconciler(newAppSource())
+		result, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+
+		project := argoCD.project("my-project")
+		Expect(project).NotTo(BeNil())
+		Expect(project.Labels).To(HaveKeyWithValue(appsource.ManagedByLabel, appsource.ManagedByValue))
+		Expect(project.Spec.Description).To(Equal("Team project"))
+		Expect(project.Spec.Destinations).To(ConsistOf(destination))
+
+		app := argoCD.application("guestbook")
+		Expect(app).NotTo(BeNil())
+		Expect(app.Spec.Project).To(Equal("my-project"))
+		Expect(app.Spec.Destination).To(Equal(destination))
+		Expect(app.Spec.Source.Path).To(Equal("guestbook"))
+		Expect(app.Annotations).To(HaveKeyWithValue(appsource.OwnerUIDAnnotation, "1234"))
+		Expect(argoCD.recordedCalls()).To(ContainElements("project.Create my-project", "project.Update my-project", "application.Create guestbook"))
+
+		appSource := getAppSource()
+		Expect(appSource.Finalizers).To(ConsistOf(applicationFinalizer))
+		Expect(appSource.Status.ApplicationName).To(Equal("guestbook"))
+		Expect(appSource.Status.ArgocdInstance).To(Equal(defaultInstance))
+		Expect(appSource.Status.Profile).To(Equal(&appsource.AppSourceProfileMatch{
+			Name:    "my-project",
+			Groups:  map[string]string{"project": "my-project", "1": "my-project", "2": "west", "3": "2"},
+			Project: "my-project",
+		}))
+		Expect(conditionOf(appSource, appsource.ApplicationCreationSuccess)).NotTo(BeNil())
+		Expect(recorder.Events).To(Receive(Equal("Normal ProjectCreated Created project my-project")))
+	})
+
+	It("appends the destination to a project managed by someone else", func() {
+		argoCD.projects["my-project"] = &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-project", Labels: map[string]string{appsource.ManagedByLabel: "admin"}},
+			Spec:       argocd.AppProjectSpec{SourceRepos: []string{"*"}},
+		}
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(argoCD.recordedCalls()).NotTo(ContainElement("project.Create my-project"))
+		project := argoCD.project("my-project")
+		Expect(project.Labels).To(HaveKeyWithValue(appsource.ManagedByLabel, "admin"))
+		Expect(project.Spec.Destinations).To(ConsistOf(destination))
+		Expect(project.Spec.SourceRepos).To(ConsistOf("*"))
+	})
+
+	It("adopts unlabeled projects whose destinations were tracked by earlier controller versions", func() {
+		other := argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: "my-project-us-east-1"}
+		argoCD.projects["my-project"] = &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-project", Annotations: map[string]string{
+				projectDestinationsAnnotation: `[{"server":"https://kubernetes.default.svc","namespace":"my-project-us-east-1"}]`,
+			}},
+			Spec: argocd.AppProjectSpec{SourceRepos: []string{"*"}, Destinations: []argocd.ApplicationDestination{other}},
+		}
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		project := argoCD.project("my-project")
+		Expect(project.Labels).To(HaveKeyWithValue(appsource.ManagedByLabel, appsource.ManagedByValue))
+		Expect(project.Spec.Description).To(Equal("Team project"))
+		Expect(project.Spec.SourceRepos).To(ConsistOf("https://github.com/argoproj/*"))
+		Expect(project.Spec.Destinations).To(ConsistOf(other, destination))
+		Expect(recorder.Events).To(Receive(Equal("Normal ProjectAdopted Adopted project my-project")))
+	})
+
+	It("leaves unlabeled projects created by someone else untouched", func() {
+		other := argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: "admin"}
+		argoCD.projects["my-project"] = &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-project"},
+			Spec:       argocd.AppProjectSpec{SourceRepos: []string{"*"}, Destinations: []argocd.ApplicationDestination{other}},
+		}
+		before := argoCD.project("my-project").DeepCopy()
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+
+		Expect(argoCD.project("my-project")).To(Equal(before))
+		Expect(argoCD.applications).NotTo(HaveKey("guestbook"))
+		Expect(conditionOf(getAppSource(), appsource.ProjectConflict).Message).To(ContainSubstring(appsource.AdoptAnnotation))
+
+		// Annotating the project opts it in
+		argoCD.projects["my-project"].Annotations = map[string]string{appsource.AdoptAnnotation: "true"}
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		project := argoCD.project("my-project")
+		Expect(project.Labels).To(HaveKeyWithValue(appsource.ManagedByLabel, appsource.ManagedByValue))
+		Expect(project.Spec.Destinations).To(ConsistOf(other, destination))
+		Expect(conditionOf(getAppSource(), appsource.ProjectConflict)).To(BeNil())
+	})
+
+	It("never adopts the default project", func() {
+		Expect(isAdoptableProject(&argocd.AppProject{ObjectMeta: metav1.ObjectMeta{
+			Name:        defaultProject,
+			Annotations: map[string]string{appsource.AdoptAnnotation: "true"},
+		}})).To(BeFalse())
+		Expect(isAdoptableProject(&argocd.AppProject{ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-project",
+			Annotations: map[string]string{appsource.AdoptAnnotation: "true"},
+		}})).To(BeTrue())
+	})
+
+	It("updates the Application when the AppSource changes", func() {
+		appSource := newAppSource()
+		newReconciler(appSource)
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		appSource = getAppSource()
+		appSource.Spec.TargetRevision = "v1.0.0"
+		Expect(r.Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(argoCD.application("guestbook").Spec.Source.TargetRevision).To(Equal("v1.0.0"))
+		Expect(conditionOf(getAppSource(), appsource.ApplicationUpdateSuccess)).NotTo(BeNil())
+	})
+
+	It("surfaces Application lookup errors other than not found", func() {
+		argoCD.fail("application.Get", status.Error(codes.Unavailable, "connection refused"))
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+		condition := conditionOf(getAppSource(), appsource.ApplicationUnknownError)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("connection refused"))
+		Expect(argoCD.recordedCalls()).NotTo(ContainElement("application.Create guestbook"))
+	})
+
+	It("mirrors the Application status and requeues to keep it fresh", func() {
+		newReconciler(newAppSource())
+		r.StatusRefreshInterval = time.Minute
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		app := argoCD.application("guestbook")
+		app.Status.Sync.Status = argocd.SyncStatusCodeSynced
+		app.Status.Health.Status = "Healthy"
+		argoCD.applications["guestbook"] = app
+		result, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+		appSource := getAppSource()
+		Expect(appSource.Status.Sync).To(Equal("Synced"))
+		Expect(appSource.Status.Health).To(Equal("Healthy"))
+	})
+
+	It("keeps the destination and project of existing Applications", func() {
+		appSource := newAppSource()
+		newReconciler(appSource)
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		moved := argocd.ApplicationDestination{Server: "https://other.example.com", Namespace: namespace}
+		app := argoCD.application("guestbook")
+		app.Spec.Destination = moved
+		app.Spec.Project = "other"
+		argoCD.applications["guestbook"] = app
+		appSource = getAppSource()
+		appSource.Spec.Path = "helm-guestbook"
+		Expect(r.Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		app = argoCD.application("guestbook")
+		Expect(app.Spec.Source.Path).To(Equal("helm-guestbook"))
+		Expect(app.Spec.Destination).To(Equal(moved))
+		Expect(app.Spec.Project).To(Equal("other"))
+	})
+
+	It("reports Application creation errors", func() {
+		argoCD.fail("application.Create", status.Error(codes.PermissionDenied, "permission denied"))
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+		condition := conditionOf(getAppSource(), appsource.ApplicationCreationError)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("permission denied"))
+		Expect(argoCD.application("guestbook")).To(BeNil())
+
+		// The error is cleared once the Application is created
+		argoCD.fail("application.Create", nil)
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		appSource := getAppSource()
+		Expect(conditionOf(appSource, appsource.ApplicationCreationError)).To(BeNil())
+		Expect(conditionOf(appSource, appsource.ApplicationCreationSuccess)).NotTo(BeNil())
+	})
+
+	It("clears Application update errors once the Application is updated", func() {
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		argoCD.fail("application.Update", status.Error(codes.Unavailable, "connection refused"))
+		appSource := getAppSource()
+		appSource.Spec.TargetRevision = "v1.0.0"
+		Expect(r.Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		Expect(conditionOf(getAppSource(), appsource.ApplicationUpdateError)).NotTo(BeNil())
+
+		argoCD.fail("application.Update", nil)
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(getAppSource(), appsource.ApplicationUpdateError)).To(BeNil())
+	})
+
+	It("reports missing AppSource namespaces", func() {
+		newReconciler(newAppSource())
+		Expect(r.Delete(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+
+		condition := conditionOf(getAppSource(), appsource.ApplicationUnknownError)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(HavePrefix("unable to get namespace " + namespace))
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+	})
+
+	It("reports unknown ArgoCD instances and clears the error once reconciled", func() {
+		appSource := newAppSource()
+		appSource.Status.ArgocdInstance = "payments"
+		newReconciler(appSource)
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+		Expect(conditionOf(getAppSource(), appsource.ApplicationUnknownError)).NotTo(BeNil())
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+
+		appSource = getAppSource()
+		appSource.Status.ArgocdInstance = ""
+		Expect(r.Status().Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(getAppSource(), appsource.ApplicationUnknownError)).To(BeNil())
+	})
+
+	It("reports projects whose tracked destinations cannot be read", func() {
+		argoCD.projects["my-project"] = &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-project",
+				Labels:      map[string]string{appsource.ManagedByLabel: appsource.ManagedByValue},
+				Annotations: map[string]string{projectDestinationsAnnotation: "not json"},
+			},
+		}
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+
+		condition := conditionOf(getAppSource(), appsource.ApplicationCreationError)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("invalid " + projectDestinationsAnnotation + " annotation"))
+		Expect(argoCD.application("guestbook")).To(BeNil())
+	})
+
+	It("reports projects which cannot be synced with their profile", func() {
+		argoCD.projects["my-project"] = &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "my-project",
+				Labels: map[string]string{appsource.ManagedByLabel: appsource.ManagedByValue},
+			},
+			Spec: argocd.AppProjectSpec{SourceRepos: []string{"*"}},
+		}
+		argoCD.fail("project.Update", status.Error(codes.PermissionDenied, "permission denied"))
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+		condition := conditionOf(getAppSource(), appsource.ApplicationCreationError)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(HavePrefix("unable to update project my-project to match its profile"))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning ApplicationCreationError")))
+		Expect(argoCD.application("guestbook")).To(BeNil())
+	})
+
+	It("reports AppSources which are not permitted by their profile", func() {
+		appSource := newAppSource()
+		appSource.Spec.RepoURL = "https://github.com/someone-else/apps"
+		newReconciler(appSource)
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+
+		appSource = getAppSource()
+		Expect(conditionOf(appSource, appsource.ApplicationInvalidSpecError)).NotTo(BeNil())
+		Expect(appSource.Status.Profile.Name).To(Equal("my-project"))
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+	})
+
+	It("refuses to take over Applications owned by someone else", func() {
+		argoCD.projects["my-project"] = &argocd.AppProject{ObjectMeta: metav1.ObjectMeta{
+			Name:   "my-project",
+			Labels: map[string]string{appsource.ManagedByLabel: appsource.ManagedByValue},
+		}}
+		argoCD.applications["guestbook"] = &argocd.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook"},
+			Spec:       argocd.ApplicationSpec{Project: "default", Destination: argocd.ApplicationDestination{Namespace: "guestbook"}},
+		}
+		newReconciler(newAppSource())
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+
+		Expect(conditionOf(getAppSource(), appsource.ApplicationConflict)).NotTo(BeNil())
+		Expect(argoCD.recordedCalls()).NotTo(ContainElement("application.Update guestbook"))
+	})
+
+	It("deletes the Application and releases the finalizer", func() {
+		newReconciler(newDeletedAppSource(appsource.DeletionModeOrphan))
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(argoCD.application("guestbook")).To(BeNil())
+		Expect(argoCD.recordedCalls()).To(ContainElement("application.Delete guestbook"))
+		Expect(getAppSource().Finalizers).To(BeEmpty())
+	})
+
+	It("waits for cascade-foreground deletions to finish", func() {
+		newReconciler(newDeletedAppSource(appsource.DeletionModeCascadeForeground))
+		result, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		appSource := getAppSource()
+		Expect(appSource.Finalizers).To(ConsistOf(applicationFinalizer))
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionInProgress)).NotTo(BeNil())
+
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getAppSource().Finalizers).To(BeEmpty())
+	})
+
+	It("deletes the Application before timing the cascade-foreground deletion", func() {
+		appSource := newDeletedAppSource(appsource.DeletionModeCascadeForeground)
+		appSource.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+		newReconciler(appSource)
+		r.DeletionTimeout = time.Minute
+		result, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(argoCD.recordedCalls()).To(ContainElement("application.Delete guestbook"))
+		Expect(conditionOf(getAppSource(), appsource.ApplicationDeletionInProgress)).NotTo(BeNil())
+	})
+
+	It("polls Applications whose deletion is in progress", func() {
+		appSource := newDeletedAppSource(appsource.DeletionModeCascadeForeground)
+		argoCD.applications["guestbook"].DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		newReconciler(appSource)
+		r.DeletionTimeout = time.Minute
+		for i := 0; i < 2; i++ {
+			result, err := reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Second))
+		}
+		Expect(argoCD.recordedCalls()).NotTo(ContainElement("application.Delete guestbook"))
+		appSource = getAppSource()
+		Expect(appSource.Finalizers).To(ConsistOf(applicationFinalizer))
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionInProgress)).NotTo(BeNil())
+	})
+
+	It("reports timed out cascade-foreground deletions and releases the finalizer on request", func() {
+		appSource := newDeletedAppSource(appsource.DeletionModeCascadeForeground)
+		argoCD.applications["guestbook"].DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		appSource.Status.Conditions = []appsource.AppSourceCondition{{
+			Type:       appsource.ApplicationDeletionInProgress,
+			Message:    appsource.ApplicationDeletingMsg,
+			Status:     appsource.ConditionTrue,
+			ObservedAt: metav1.Time{Time: time.Now().Add(-time.Hour)},
+		}}
+		newReconciler(appSource)
+		r.DeletionTimeout = time.Minute
+		_, err := reconcile()
+		Expect(err).To(HaveOccurred())
+		appSource = getAppSource()
+		Expect(appSource.Finalizers).To(ConsistOf(applicationFinalizer))
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionError).Message).To(ContainSubstring(appsource.ReleaseFinalizerAnnotation))
+
+		appSource.Annotations = map[string]string{appsource.ReleaseFinalizerAnnotation: "true"}
+		Expect(r.Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getAppSource().Finalizers).To(BeEmpty())
+		Expect(argoCD.application("guestbook")).NotTo(BeNil())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning ApplicationDeletionError")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning FinalizerReleased")))
+	})
+
+	It("keeps the finalizer when the Application cannot be deleted", func() {
+		argoCD.fail("application.Delete", status.Error(codes.Unavailable, "connection refused"))
+		newReconciler(newDeletedAppSource(appsource.DeletionModeCascadeBackground))
+		_, err := reconcile()
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+		appSource := getAppSource()
+		Expect(appSource.Finalizers).To(ConsistOf(applicationFinalizer))
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionError)).NotTo(BeNil())
+		Expect(argoCD.application("guestbook")).NotTo(BeNil())
+	})
+
+	It("clears Application deletion errors once the deletion is in progress", func() {
+		argoCD.fail("application.Delete", status.Error(codes.Unavailable, "connection refused"))
+		newReconciler(newDeletedAppSource(appsource.DeletionModeCascadeForeground))
+		_, err := reconcile()
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		Expect(conditionOf(getAppSource(), appsource.ApplicationDeletionError)).NotTo(BeNil())
+
+		argoCD.fail("application.Delete", nil)
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		appSource := getAppSource()
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionError)).To(BeNil())
+		Expect(conditionOf(appSource, appsource.ApplicationDeletionInProgress)).NotTo(BeNil())
+	})
+
+	It("manages Applications as Kubernetes resources with the kubernetes backend", func() {
+		newReconciler(newAppSource())
+		configMap := &v1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, configMap)).To(Succeed())
+		configMap.Data["argocd.backend"] = BackendKubernetes
+		Expect(r.Update(ctx, configMap)).To(Succeed())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		app := &argocd.Application{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: "guestbook"}, app)).To(Succeed())
+		Expect(app.Spec.Project).To(Equal("my-project"))
+		project := &argocd.AppProject{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: "my-project"}, project)).To(Succeed())
+		Expect(project.Spec.Destinations).To(ConsistOf(destination))
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+	})
+
+	It("deploys to the cluster selected by the profile destination", func() {
+		newReconciler(newAppSource())
+		configMap := &v1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, configMap)).To(Succeed())
+		configMap.Data["project.profiles"] = `
+- my-project:
+    namePattern: (?P<project>.*)-us-(?P<region>west|east)-(\d.*)
+    destination:
+      name: 'us-{{ .Groups.region }}'
+    spec:
+      sourceRepos:
+      - '*'
+`
+		Expect(r.Update(ctx, configMap)).To(Succeed())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+
+		remote := argocd.ApplicationDestination{Name: "us-west", Namespace: namespace}
+		Expect(argoCD.application("guestbook").Spec.Destination).To(Equal(remote))
+		Expect(argoCD.project("my-project").Spec.Destinations).To(ConsistOf(remote))
+		Expect(recorder.Events).To(Receive(Equal("Normal ProjectCreated Created project my-project")))
+		Expect(recorder.Events).To(Receive(Equal("Normal ProjectDestinationAdded Added destination us-west/my-project-us-west-2 to project my-project")))
+	})
+
+	It("keeps using the ArgoCD instance holding the Application", func() {
+		payments := newFakeArgoCD()
+		newReconciler(newAppSource())
+		r.ArgoCD = &ClientManager{NewClients: func(opts *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+			if opts.ServerAddr == "argocd-server.payments.svc:443" {
+				return payments.newClients(opts)
+			}
+			return argoCD.newClients(opts)
+		}}
+		configMap := &v1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, configMap)).To(Succeed())
+		configMap.Data["argocd.instances"] = `
+payments:
+  address: argocd-server.payments.svc:443
+`
+		configMap.Data["project.profiles"] = `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    argocdInstance: payments
+    spec:
+      sourceRepos:
+      - '*'
+`
+		Expect(r.Update(ctx, configMap)).To(Succeed())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payments.application("guestbook")).NotTo(BeNil())
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+		Expect(getAppSource().Status.ArgocdInstance).To(Equal("payments"))
+
+		// Move the profile back to the default instance, then delete the AppSource
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, configMap)).To(Succeed())
+		configMap.Data["project.profiles"] = strings.Replace(configMap.Data["project.profiles"], "argocdInstance: payments", "", 1)
+		Expect(r.Update(ctx, configMap)).To(Succeed())
+		appSource := getAppSource()
+		appSource.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		Expect(r.Update(ctx, appSource)).To(Succeed())
+		_, err = reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payments.application("guestbook")).To(BeNil())
+		Expect(argoCD.recordedCalls()).To(BeEmpty())
+	})
+
+	It("selects profiles by namespace labels", func() {
+		newReconciler(newAppSource())
+		configMap := &v1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}, configMap)).To(Succeed())
+		configMap.Data["project.profiles"] = `
+- labeled:
+    namespaceSelector:
+      matchLabels:
+        team: guestbook
+    projectNameTemplate: 'team-{{ .Labels.team }}'
+    spec:
+      sourceRepos:
+      - '*'
+`
+		Expect(r.Update(ctx, configMap)).To(Succeed())
+		_, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(argoCD.project("team-guestbook")).NotTo(BeNil())
+		Expect(argoCD.application("guestbook").Spec.Project).To(Equal("team-guestbook"))
+
+		Expect(r.namespaceToAppSources(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(ConsistOf(
+			ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "guestbook"}}))
+	})
+
+	It("reads API token Secrets through informers", func() {
+		tokenSecrets := NewSecretInformers(k8sfake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: appsource.ArgocdNamespace, Name: "argocd-token"},
+			Data:       map[string][]byte{"token": []byte("secret-token\n")},
+		}))
+		go tokenSecrets.Start(ctx)
+		r := &AppSourceReconciler{TokenSecrets: tokenSecrets}
+		token, err := r.GetAuthToken(ctx, &ArgoCDInstance{
+			Name:           defaultInstance,
+			TokenSecretRef: &TokenSecretRef{Namespace: appsource.ArgocdNamespace, Name: "argocd-token", Key: "token"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("secret-token"))
+	})
+
+	It("reconnects the ArgoCD clients and requeues AppSources when their token Secret changes", func() {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: appsource.ArgocdNamespace, Name: "argocd-token", ResourceVersion: "1"},
+			Data:       map[string][]byte{"token": []byte("secret-token")},
+		}
+		clientset := k8sfake.NewSimpleClientset(secret)
+		newReconciler(newAppSource())
+		r.TokenSecrets = NewSecretInformers(clientset)
+		r.TokenSecrets.OnChange = r.tokenSecretChanged
+		r.tokenSecretEvents = make(chan event.GenericEvent, 1)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go r.TokenSecrets.Start(ctx)
+
+		configMap := &v1.ConfigMap{}
+		Expect(r.Get(ctx, r.Config.ConfigMap, configMap)).To(Succeed())
+		configMap.Data["argocd.tokenSecretRef"] = "{name: argocd-token, key: token}"
+		configMap.ResourceVersion = "token"
+		config, err := r.Config.Update(ctx, configMap)
+		Expect(err).NotTo(HaveOccurred())
+		instance, err := config.Instance("")
+		Expect(err).NotTo(HaveOccurred())
+		clients, err := r.UpsertArgoCDClients(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+
+		secret.Data["token"] = []byte("rotated-token")
+		secret.ResourceVersion = "2"
+		_, err = clientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		var changed event.GenericEvent
+		Eventually(r.tokenSecretEvents).Should(Receive(&changed))
+		Expect(r.tokenSecretToAppSources(changed.Object)).To(ConsistOf(
+			ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "guestbook"}}))
+		// The clients were closed, so they are replaced even for identical options
+		opts, err := r.GetClientOpts(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.AuthToken).To(Equal("rotated-token"))
+		reconnected, err := r.ArgoCD.Clients(instance.Name, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconnected).NotTo(BeIdenticalTo(clients))
+	})
+})
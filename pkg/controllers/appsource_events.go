@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// warningConditions are the condition types reported as Warning events
+var warningConditions = map[appsource.AppSourceConditionType]bool{
+	appsource.ApplicationCreationError:    true,
+	appsource.ApplicationUpdateError:      true,
+	appsource.ApplicationDeletionError:    true,
+	appsource.ApplicationConflict:         true,
+	appsource.ProjectConflict:             true,
+	appsource.ApplicationInvalidSpecError: true,
+	appsource.ApplicationUnknownError:     true,
+}
+
+// recordCondition upserts the condition and records it as an event on the AppSource. Conditions which are
+// already present unchanged are not recorded again, so reconciles in a steady state do not emit events
+func (r *AppSourceReconciler) recordCondition(appSource *appsource.AppSource, condition appsource.AppSourceCondition) {
+	if !appSource.UpsertConditions(condition) {
+		return
+	}
+	eventType := v1.EventTypeNormal
+	if warningConditions[condition.Type] {
+		eventType = v1.EventTypeWarning
+	}
+	r.Recorder.Event(appSource, eventType, condition.Type, condition.Message)
+}
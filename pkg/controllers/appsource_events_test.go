@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource events", func() {
+	var recorder *record.FakeRecorder
+	var r *AppSourceReconciler
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		r = &AppSourceReconciler{Recorder: recorder}
+	})
+	newCondition := func(conditionType, message string) appsource.AppSourceCondition {
+		return appsource.AppSourceCondition{Type: conditionType, Message: message, Status: appsource.ConditionFalse, ObservedAt: metav1.Now()}
+	}
+
+	It("records condition changes once", func() {
+		appSource := &appsource.AppSource{}
+		r.recordCondition(appSource, newCondition(appsource.ApplicationCreationError, "connection refused"))
+		r.recordCondition(appSource, newCondition(appsource.ApplicationCreationError, "connection refused"))
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(Equal("Warning ApplicationCreationError connection refused"))
+
+		r.recordCondition(appSource, newCondition(appsource.ApplicationCreationError, "permission denied"))
+		Expect(<-recorder.Events).To(Equal("Warning ApplicationCreationError permission denied"))
+	})
+
+	It("records progress as Normal events", func() {
+		r.recordCondition(&appsource.AppSource{}, appsource.AppSourceCondition{
+			Type:    appsource.ApplicationDeletionInProgress,
+			Message: appsource.ApplicationDeletingMsg,
+			Status:  appsource.ConditionTrue,
+		})
+		Expect(<-recorder.Events).To(HavePrefix("Normal ApplicationDeletionInProgress"))
+	})
+})
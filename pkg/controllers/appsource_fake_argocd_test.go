@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	argocdClientSet "github.com/argoproj/argo-cd/v2/pkg/apiclient"
+	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	projectTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeArgoCD is an in-process ArgoCD API server holding Applications and Projects in memory.
+// Calls are recorded as "<service>.<Method> <name>" and failures can be injected per call
+type fakeArgoCD struct {
+	lock         sync.Mutex
+	applications map[string]*argocd.Application
+	projects     map[string]*argocd.AppProject
+	calls        []string
+	// failures maps "<service>.<Method>" to the error returned by the next calls
+	failures map[string]error
+}
+
+func newFakeArgoCD() *fakeArgoCD {
+	return &fakeArgoCD{
+		applications: map[string]*argocd.Application{},
+		projects:     map[string]*argocd.AppProject{},
+		failures:     map[string]error{},
+	}
+}
+
+// newClients returns clients backed by the fake, it can be used as ClientManager.NewClients
+func (f *fakeArgoCD) newClients(_ *argocdClientSet.ClientOptions) (*ArgoCDClients, error) {
+	clients := &ArgoCDClients{}
+	clients.Applications.Client = &fakeApplicationClient{fake: f}
+	clients.Applications.Closer = fakeCloser{}
+	clients.Projects.Client = &fakeProjectClient{fake: f}
+	clients.Projects.Closer = fakeCloser{}
+	return clients, nil
+}
+
+// fail makes calls to method fail with err until it is cleared with a nil err
+func (f *fakeArgoCD) fail(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err == nil {
+		delete(f.failures, method)
+		return
+	}
+	f.failures[method] = err
+}
+
+// recordedCalls returns the calls recorded so far
+func (f *fakeArgoCD) recordedCalls() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+// application returns a copy of the named Application, or nil
+func (f *fakeArgoCD) application(name string) *argocd.Application {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.applications[name].DeepCopy()
+}
+
+// project returns a copy of the named Project, or nil
+func (f *fakeArgoCD) project(name string) *argocd.AppProject {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.projects[name].DeepCopy()
+}
+
+// record records a call and returns its injected failure. The lock must be held
+func (f *fakeArgoCD) record(method, name string) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s %s", method, name))
+	return f.failures[method]
+}
+
+type fakeCloser struct{}
+
+func (fakeCloser) Close() error { return nil }
+
+// fakeApplicationClient implements the ApplicationServiceClient methods used by the controller
+type fakeApplicationClient struct {
+	applicationTypes.ApplicationServiceClient
+	fake *fakeArgoCD
+}
+
+func (c *fakeApplicationClient) Get(ctx context.Context, in *applicationTypes.ApplicationQuery, opts ...grpc.CallOption) (*argocd.Application, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("application.Get", *in.Name); err != nil {
+		return nil, err
+	}
+	app, ok := c.fake.applications[*in.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", *in.Name)
+	}
+	return app.DeepCopy(), nil
+}
+
+func (c *fakeApplicationClient) List(ctx context.Context, in *applicationTypes.ApplicationQuery, opts ...grpc.CallOption) (*argocd.ApplicationList, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("application.List", ""); err != nil {
+		return nil, err
+	}
+	list := &argocd.ApplicationList{}
+	for _, app := range c.fake.applications {
+		for _, project := range in.Projects {
+			if app.Spec.Project == project {
+				list.Items = append(list.Items, *app.DeepCopy())
+			}
+		}
+	}
+	return list, nil
+}
+
+func (c *fakeApplicationClient) Create(ctx context.Context, in *applicationTypes.ApplicationCreateRequest, opts ...grpc.CallOption) (*argocd.Application, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("application.Create", in.Application.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.applications[in.Application.Name]; ok {
+		return nil, status.Errorf(codes.InvalidArgument, "existing application spec is different, use upsert flag to force update")
+	}
+	if _, ok := c.fake.projects[in.Application.Spec.Project]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "application references project %s which does not exist", in.Application.Spec.Project)
+	}
+	c.fake.applications[in.Application.Name] = in.Application.DeepCopy()
+	return in.Application.DeepCopy(), nil
+}
+
+func (c *fakeApplicationClient) Update(ctx context.Context, in *applicationTypes.ApplicationUpdateRequest, opts ...grpc.CallOption) (*argocd.Application, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("application.Update", in.Application.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.applications[in.Application.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", in.Application.Name)
+	}
+	c.fake.applications[in.Application.Name] = in.Application.DeepCopy()
+	return in.Application.DeepCopy(), nil
+}
+
+func (c *fakeApplicationClient) Delete(ctx context.Context, in *applicationTypes.ApplicationDeleteRequest, opts ...grpc.CallOption) (*applicationTypes.ApplicationResponse, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("application.Delete", *in.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.applications[*in.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", *in.Name)
+	}
+	delete(c.fake.applications, *in.Name)
+	return &applicationTypes.ApplicationResponse{}, nil
+}
+
+// fakeProjectClient implements the ProjectServiceClient methods used by the controller
+type fakeProjectClient struct {
+	projectTypes.ProjectServiceClient
+	fake *fakeArgoCD
+}
+
+func (c *fakeProjectClient) Get(ctx context.Context, in *projectTypes.ProjectQuery, opts ...grpc.CallOption) (*argocd.AppProject, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("project.Get", in.Name); err != nil {
+		return nil, err
+	}
+	project, ok := c.fake.projects[in.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "appprojects.argoproj.io %q not found", in.Name)
+	}
+	return project.DeepCopy(), nil
+}
+
+func (c *fakeProjectClient) Create(ctx context.Context, in *projectTypes.ProjectCreateRequest, opts ...grpc.CallOption) (*argocd.AppProject, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("project.Create", in.Project.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.projects[in.Project.Name]; ok && !in.Upsert {
+		return nil, status.Errorf(codes.InvalidArgument, "existing project spec is different, use upsert flag to force update")
+	}
+	c.fake.projects[in.Project.Name] = in.Project.DeepCopy()
+	return in.Project.DeepCopy(), nil
+}
+
+func (c *fakeProjectClient) Update(ctx context.Context, in *projectTypes.ProjectUpdateRequest, opts ...grpc.CallOption) (*argocd.AppProject, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("project.Update", in.Project.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.projects[in.Project.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "appprojects.argoproj.io %q not found", in.Project.Name)
+	}
+	c.fake.projects[in.Project.Name] = in.Project.DeepCopy()
+	return in.Project.DeepCopy(), nil
+}
+
+func (c *fakeProjectClient) Delete(ctx context.Context, in *projectTypes.ProjectQuery, opts ...grpc.CallOption) (*projectTypes.EmptyResponse, error) {
+	c.fake.lock.Lock()
+	defer c.fake.lock.Unlock()
+	if err := c.fake.record("project.Delete", in.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.fake.projects[in.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "appprojects.argoproj.io %q not found", in.Name)
+	}
+	delete(c.fake.projects, in.Name)
+	return &projectTypes.EmptyResponse{}, nil
+}
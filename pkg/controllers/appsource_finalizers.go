@@ -2,62 +2,180 @@ package controllers
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
-	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
 )
 
+const (
+	// applicationFinalizer is added to every AppSource, the Application is deleted according to the deletion mode
+	applicationFinalizer = "application-finalizer.appsource.argoproj.io"
+	// cascadeFinalizer is the legacy finalizer requesting a cascade-background deletion
+	cascadeFinalizer = "application-finalizer.appsource.argoproj.io/cascade"
+)
+
 var (
 	finalizers = []string{
-		"application-finalizer.appsource.argoproj.io",
-		"application-finalizer.appsource.argoproj.io/cascade",
+		applicationFinalizer,
+		cascadeFinalizer,
 	}
 	cascadeFalse bool   = false
 	cascadeTrue  bool   = true
+	foreground   string = "foreground"
 	background   string = "background"
 )
 
-func (r *AppSourceReconciler) ResolveFinalizers(ctx context.Context, appSource *appsource.AppSource) (err error) {
+// ensureFinalizer Adds the default finalizer to AppSources which have none, so that
+// deleting the AppSource also deletes its ArgoCD Application
+func (r *AppSourceReconciler) ensureFinalizer(ctx context.Context, appSource *appsource.AppSource) error {
+	for _, finalizer := range finalizers {
+		if controllerutil.ContainsFinalizer(appSource, finalizer) {
+			return nil
+		}
+	}
+	controllerutil.AddFinalizer(appSource, applicationFinalizer)
+	return r.Update(ctx, appSource)
+}
+
+// deletionMode Returns how the ArgoCD Application is deleted when resolving finalizer. The AppSource
+// deletionMode comes first, then the legacy cascade finalizer, then the profile default. proj may be nil
+func deletionMode(appSource *appsource.AppSource, proj *ProjectTemplate, finalizer string) appsource.DeletionMode {
+	if appSource.Spec.DeletionMode != "" {
+		return appSource.Spec.DeletionMode
+	}
+	if finalizer == cascadeFinalizer {
+		return appsource.DeletionModeCascadeBackground
+	}
+	if proj != nil && proj.DeletionMode != "" {
+		return proj.DeletionMode
+	}
+	return appsource.DeletionModeOrphan
+}
+
+// ResolveFinalizers Deletes the ArgoCD Application according to the AppSource deletion mode, then prunes the
+// project destination and the project itself if the matching profile enables pruneProject. proj may be nil.
+// In cascade-foreground mode the finalizer is only released once the Application is gone, the AppSource
+// is requeued until then. Once the deletion timed out, the finalizer is released if the AppSource has the
+// release-finalizer annotation
+func (r *AppSourceReconciler) ResolveFinalizers(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate) (result ctrl.Result, err error) {
+	appName, err := applicationName(appSource, proj)
+	if err != nil {
+		// No Application can have been created with a name which does not render
+		appName = appSource.Name
+	}
 	for _, appSourceFinalizer := range appSource.GetFinalizers() {
 		for _, finalizer := range finalizers {
 			if appSourceFinalizer == finalizer {
 
-				switch finalizer {
-				case "application-finalizer.appsource.argoproj.io":
-					_, err = r.Clients.Applications.Client.Delete(ctx, &applicationTypes.ApplicationDeleteRequest{
-						Name:    &appSource.Name,
-						Cascade: &cascadeFalse,
-					})
-				case "application-finalizer.appsource.argoproj.io/cascade":
-					_, err = r.Clients.Applications.Client.Delete(ctx, &applicationTypes.ApplicationDeleteRequest{
-						Name:              &appSource.Name,
-						Cascade:           &cascadeTrue,
-						PropagationPolicy: &background,
-					})
+				var app *v1alpha1.Application
+				app, err = backend.GetApplication(ctx, appName)
+				if isNotFound(err) {
+					app, err = nil, nil
+				}
+				if app != nil && r.checkApplicationOwner(appSource, app) != nil {
+					// Leave Applications owned by someone else alone
+					app = nil
+				}
+
+				switch mode := deletionMode(appSource, proj, finalizer); {
+				case err != nil || app == nil:
+					// Nothing to delete
+				case mode == appsource.DeletionModeCascadeForeground:
+					var deleted bool
+					if deleted, err = r.deleteApplicationForeground(ctx, backend, appSource, app); err == nil && !deleted {
+						// Check again later, the Application resources are still being deleted
+						return ctrl.Result{RequeueAfter: r.DeletionPollInterval}, nil
+					}
+					if _, timedOut := err.(*deletionTimeoutError); timedOut && appSource.Annotations[appsource.ReleaseFinalizerAnnotation] == "true" {
+						r.Recorder.Eventf(appSource, v1.EventTypeWarning, "FinalizerReleased",
+							"Released the finalizer after the deletion of application %s timed out, the application is left in ArgoCD", appName)
+						err, app = nil, nil
+					}
 				default:
-					err = errors.New("invalid finalizer")
+					err = backend.DeleteApplication(ctx, appName, mode)
+				}
+				if isNotFound(err) {
+					// Application is already gone
+					err = nil
+				}
+				if err == nil && proj != nil && proj.PruneProject {
+					var destination v1alpha1.ApplicationDestination
+					if destination, err = proj.GetDestination(appSource, r.ClusterHost); err == nil {
+						err = r.pruneProject(ctx, backend, appSource, proj, appName, destination)
+					}
 				}
 
 				if err != nil {
-					appSource.UpsertConditions(appsource.AppSourceCondition{
+					r.recordCondition(appSource, appsource.AppSourceCondition{
 						Type:       appsource.ApplicationDeletionError,
 						Message:    err.Error(),
 						Status:     appsource.ConditionFalse,
 						ObservedAt: metav1.Now(),
 					})
-					return err
+					return ctrl.Result{}, err
+				}
+				appSource.RemoveCondition(appsource.ApplicationDeletionError)
+				if app != nil {
+					r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ApplicationDeleted", "Deleted application %s (%s)",
+						appName, deletionMode(appSource, proj, finalizer))
 				}
 				controllerutil.RemoveFinalizer(appSource, finalizer)
 				if err = r.Update(ctx, appSource); err != nil {
-					return err
+					return ctrl.Result{}, err
 				}
-				return nil
+				return ctrl.Result{}, nil
+			}
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// deletionTimeoutError reports a cascade-foreground deletion which did not complete in time
+type deletionTimeoutError struct {
+	app     string
+	timeout time.Duration
+}
+
+func (e *deletionTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for application %s to be deleted, annotate the AppSource with %s: \"true\" to release its finalizer",
+		e.timeout, e.app, appsource.ReleaseFinalizerAnnotation)
+}
+
+// deleteApplicationForeground Deletes the live ArgoCD Application and its resources in the foreground and returns
+// true once the Application is gone. The deletion is timed from the ApplicationDeletionInProgress condition, waiting
+// longer than the deletion timeout is reported as a deletionTimeoutError
+func (r *AppSourceReconciler) deleteApplicationForeground(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, app *v1alpha1.Application) (bool, error) {
+	if app.DeletionTimestamp.IsZero() {
+		err := backend.DeleteApplication(ctx, app.Name, appsource.DeletionModeCascadeForeground)
+		if isNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	// The condition keeps the time it was first observed while the deletion is in progress
+	r.recordCondition(appSource, appsource.AppSourceCondition{
+		Type:       appsource.ApplicationDeletionInProgress,
+		Message:    appsource.ApplicationDeletingMsg,
+		Status:     appsource.ConditionTrue,
+		ObservedAt: metav1.Now(),
+	})
+	if r.DeletionTimeout > 0 {
+		for _, condition := range appSource.Status.Conditions {
+			if condition.Type == appsource.ApplicationDeletionInProgress && time.Since(condition.ObservedAt.Time) > r.DeletionTimeout {
+				return false, &deletionTimeoutError{app: app.Name, timeout: r.DeletionTimeout}
 			}
 		}
 	}
-	return nil
+	// Earlier errors deleting the Application are resolved while the deletion is in progress
+	appSource.RemoveCondition(appsource.ApplicationDeletionError)
+	return false, nil
 }
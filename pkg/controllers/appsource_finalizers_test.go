@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource deletion mode", func() {
+	newAppSource := func(mode appsource.DeletionMode) *appsource.AppSource {
+		return &appsource.AppSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "my-project-us-west-2"},
+			Spec:       appsource.AppSourceSpec{DeletionMode: mode},
+		}
+	}
+	profile := &ProjectTemplate{DeletionMode: appsource.DeletionModeCascadeForeground}
+
+	It("orphans the Application resources by default", func() {
+		Expect(deletionMode(newAppSource(""), nil, applicationFinalizer)).To(Equal(appsource.DeletionModeOrphan))
+	})
+
+	It("falls back to the profile deletion mode", func() {
+		Expect(deletionMode(newAppSource(""), profile, applicationFinalizer)).To(Equal(appsource.DeletionModeCascadeForeground))
+	})
+
+	It("keeps the legacy cascade finalizer working", func() {
+		Expect(deletionMode(newAppSource(""), profile, cascadeFinalizer)).To(Equal(appsource.DeletionModeCascadeBackground))
+	})
+
+	It("prefers the AppSource deletion mode", func() {
+		appSource := newAppSource(appsource.DeletionModeOrphan)
+		Expect(deletionMode(appSource, profile, cascadeFinalizer)).To(Equal(appsource.DeletionModeOrphan))
+	})
+})
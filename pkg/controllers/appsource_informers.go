@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ObjectInformers caches individual objects of a single kind. Each object is watched by a dedicated
+// informer restricted to its namespace and name, so only the objects read by the controller are cached
+// instead of every object of the kind in the cluster. Informers run once ObjectInformers is started
+type ObjectInformers struct {
+	// Resource of the cached kind, reported by not found errors
+	Resource schema.GroupResource
+	// Empty object of the cached kind
+	Object client.Object
+	// NewListWatch returns a ListerWatcher of the object named as key
+	NewListWatch func(key types.NamespacedName) cache.ListerWatcher
+	// OnChange, if set, is called when a cached object is updated or deleted, or created after Get reported it missing.
+	// It must be set before objects are read
+	OnChange func(obj client.Object)
+
+	lock      sync.Mutex
+	informers map[types.NamespacedName]cache.SharedIndexInformer
+	// Objects reported as not found by Get
+	missing map[types.NamespacedName]bool
+	ctx     context.Context
+	started chan struct{}
+}
+
+var _ client.Reader = &ObjectInformers{}
+
+// NewObjectInformers returns ObjectInformers caching objects of the kind of obj
+func NewObjectInformers(resource schema.GroupResource, obj client.Object, newListWatch func(key types.NamespacedName) cache.ListerWatcher) *ObjectInformers {
+	return &ObjectInformers{
+		Resource:     resource,
+		Object:       obj,
+		NewListWatch: newListWatch,
+		informers:    map[types.NamespacedName]cache.SharedIndexInformer{},
+		missing:      map[types.NamespacedName]bool{},
+		started:      make(chan struct{}),
+	}
+}
+
+// NewConfigMapInformers returns ObjectInformers caching configmaps
+func NewConfigMapInformers(clientset kubernetes.Interface) *ObjectInformers {
+	return NewObjectInformers(v1.Resource("configmaps"), &v1.ConfigMap{}, func(key types.NamespacedName) cache.ListerWatcher {
+		configMaps := clientset.CoreV1().ConfigMaps(key.Namespace)
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = nameSelector(key)
+				return configMaps.List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = nameSelector(key)
+				return configMaps.Watch(context.TODO(), options)
+			},
+		}
+	})
+}
+
+// NewSecretInformers returns ObjectInformers caching Secrets
+func NewSecretInformers(clientset kubernetes.Interface) *ObjectInformers {
+	return NewObjectInformers(v1.Resource("secrets"), &v1.Secret{}, func(key types.NamespacedName) cache.ListerWatcher {
+		secrets := clientset.CoreV1().Secrets(key.Namespace)
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = nameSelector(key)
+				return secrets.List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = nameSelector(key)
+				return secrets.Watch(context.TODO(), options)
+			},
+		}
+	})
+}
+
+// nameSelector returns the field selector of the object named as key
+func nameSelector(key types.NamespacedName) string {
+	return fields.OneTermEqualSelector("metadata.name", key.Name).String()
+}
+
+// Informer returns the informer of the object named as key, creating it if necessary
+func (o *ObjectInformers) Informer(key types.NamespacedName) cache.SharedIndexInformer {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if informer, ok := o.informers[key]; ok {
+		return informer
+	}
+	informer := cache.NewSharedIndexInformer(o.NewListWatch(key), o.Object, 0, cache.Indexers{})
+	if o.OnChange != nil {
+		// Only the named object is notified, even if the field selector is not honored
+		informer.AddEventHandler(cache.FilteringResourceEventHandler{
+			FilterFunc: func(obj interface{}) bool {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					return tombstone.Key == key.String()
+				}
+				return client.ObjectKeyFromObject(obj.(client.Object)) == key
+			},
+			Handler: cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					// Objects listed when the informer starts were not read before
+					if o.wasMissing(key) {
+						o.OnChange(obj.(client.Object))
+					}
+				},
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					if oldObj.(client.Object).GetResourceVersion() != newObj.(client.Object).GetResourceVersion() {
+						o.OnChange(newObj.(client.Object))
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if obj, ok := obj.(client.Object); ok {
+						o.OnChange(obj)
+					}
+				},
+			},
+		})
+	}
+	o.informers[key] = informer
+	if o.ctx != nil {
+		go informer.Run(o.ctx.Done())
+	}
+	return informer
+}
+
+// Start runs the informers until ctx is done, informers created later are run as soon as they are created
+func (o *ObjectInformers) Start(ctx context.Context) error {
+	o.lock.Lock()
+	o.ctx = ctx
+	for _, informer := range o.informers {
+		go informer.Run(ctx.Done())
+	}
+	close(o.started)
+	o.lock.Unlock()
+
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection returns false, the cached objects are also read by the webhooks of standby replicas
+func (o *ObjectInformers) NeedLeaderElection() bool {
+	return false
+}
+
+// Get copies the cached object named as key into obj, waiting for its informer to sync
+func (o *ObjectInformers) Get(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+	informer := o.Informer(key)
+	select {
+	case <-o.started:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("%s %s cache did not sync", o.Resource, key)
+	}
+
+	// Missing objects are recorded under the lock, so their creation cannot be handled in between
+	o.lock.Lock()
+	item, exists, err := informer.GetStore().GetByKey(key.String())
+	if err == nil && !exists {
+		o.missing[key] = true
+	}
+	o.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(o.Resource, key.Name)
+	}
+	src := reflect.ValueOf(item.(runtime.Object).DeepCopyObject())
+	dst := reflect.ValueOf(obj)
+	if src.Type() != dst.Type() {
+		return fmt.Errorf("cannot read %s into %T", o.Resource, obj)
+	}
+	dst.Elem().Set(src.Elem())
+	return nil
+}
+
+// wasMissing returns true once if Get reported the object named as key as not found
+func (o *ObjectInformers) wasMissing(key types.NamespacedName) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	missing := o.missing[key]
+	delete(o.missing, key)
+	return missing
+}
+
+// List is not supported, objects are only cached by name
+func (o *ObjectInformers) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return fmt.Errorf("%s are only cached by name and cannot be listed", o.Resource)
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Object informers", func() {
+	var (
+		ctx       context.Context
+		cancel    context.CancelFunc
+		clientset *k8sfake.Clientset
+		informers *ObjectInformers
+	)
+	key := types.NamespacedName{Namespace: "argocd", Name: DefaultConfigMapName}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clientset = k8sfake.NewSimpleClientset(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string]string{"argocd.address": "argocd-server.argocd.svc:443"},
+		})
+		informers = NewConfigMapInformers(clientset)
+		go func() {
+			defer GinkgoRecover()
+			Expect(informers.Start(ctx)).To(Succeed())
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("reads the named object from its informer", func() {
+		configMap := v1.ConfigMap{}
+		Expect(informers.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("argocd.address", "argocd-server.argocd.svc:443"))
+
+		// Callers get a copy of the cached object
+		configMap.Data["argocd.address"] = "changed"
+		Expect(informers.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("argocd.address", "argocd-server.argocd.svc:443"))
+	})
+
+	It("picks up changes to the object", func() {
+		configMap := v1.ConfigMap{}
+		Expect(informers.Get(ctx, key, &configMap)).To(Succeed())
+		configMap.Data["argocd.address"] = "argocd.example.com:443"
+		_, err := clientset.CoreV1().ConfigMaps(key.Namespace).Update(ctx, &configMap, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() string {
+			Expect(informers.Get(ctx, key, &configMap)).To(Succeed())
+			return configMap.Data["argocd.address"]
+		}).Should(Equal("argocd.example.com:443"))
+	})
+
+	It("reports missing objects as not found", func() {
+		err := informers.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "missing"}, &v1.ConfigMap{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("notifies the creation of objects reported missing, and the updates and deletion of read objects", func() {
+		changes := make(chan string, 10)
+		informers.OnChange = func(obj client.Object) {
+			changes <- obj.GetName() + "@" + obj.GetResourceVersion()
+		}
+		configMaps := clientset.CoreV1().ConfigMaps(key.Namespace)
+		missing := types.NamespacedName{Namespace: key.Namespace, Name: "missing"}
+		Expect(apierrors.IsNotFound(informers.Get(ctx, missing, &v1.ConfigMap{}))).To(BeTrue())
+		_, err := configMaps.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: missing.Name, ResourceVersion: "1"}}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(changes).Should(Receive(Equal("missing@1")))
+
+		// Objects found by the first read are not reported as created
+		configMap := v1.ConfigMap{}
+		Expect(informers.Get(ctx, key, &configMap)).To(Succeed())
+		configMap.ResourceVersion = "2"
+		_, err = configMaps.Update(ctx, &configMap, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(changes).Should(Receive(Equal(key.Name + "@2")))
+
+		Expect(configMaps.Delete(ctx, key.Name, metav1.DeleteOptions{})).To(Succeed())
+		Eventually(changes).Should(Receive(Equal(key.Name + "@2")))
+		Consistently(changes).ShouldNot(Receive())
+	})
+
+	It("refuses to read objects of another kind", func() {
+		Expect(informers.Get(ctx, key, &v1.Secret{})).NotTo(Succeed())
+	})
+
+	It("cannot list objects", func() {
+		Expect(informers.List(ctx, &v1.ConfigMapList{})).NotTo(Succeed())
+	})
+})
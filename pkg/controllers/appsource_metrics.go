@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	projectTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var (
+	argoCDRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "appsource_argocd_api_requests_total",
+		Help: "Number of ArgoCD API calls by service, method and gRPC status code",
+	}, []string{"service", "method", "code"})
+	argoCDRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "appsource_argocd_api_request_duration_seconds",
+		Help:    "Latency of ArgoCD API calls by service and method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method"})
+	profileMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "appsource_profile_matches_total",
+		Help: "Number of reconciled AppSources matched by each project profile",
+	}, []string{"profile"})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "appsource_config_reloads_total",
+		Help: "Number of AppSource configmap reloads by result",
+	}, []string{"result"})
+	appSourceConditionsDesc = prometheus.NewDesc("appsource_conditions",
+		"Number of AppSources with a condition by namespace and condition type",
+		[]string{"namespace", "type"}, nil)
+	// appSourceConditions reads AppSources from the cache of the manager set up last
+	appSourceConditions = &appSourceCollector{}
+)
+
+func init() {
+	metrics.Registry.MustRegister(argoCDRequests, argoCDRequestDuration, profileMatches, configReloads, appSourceConditions)
+}
+
+// observeArgoCDCall records the outcome and latency of an ArgoCD API call started at start
+func observeArgoCDCall(service, method string, start time.Time, err error) {
+	argoCDRequests.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	argoCDRequestDuration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+}
+
+// appSourceCollector reports the conditions of the AppSources in the manager cache at scrape time,
+// nothing is reported until a reader is set
+type appSourceCollector struct {
+	lock   sync.RWMutex
+	reader client.Reader
+}
+
+// setReader sets the reader AppSources are listed from
+func (c *appSourceCollector) setReader(reader client.Reader) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reader = reader
+}
+
+func (c *appSourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appSourceConditionsDesc
+}
+
+func (c *appSourceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	reader := c.reader
+	c.lock.RUnlock()
+	if reader == nil {
+		return
+	}
+	appSources := appsource.AppSourceList{}
+	if err := reader.List(context.Background(), &appSources); err != nil {
+		log.Log.WithName("metrics").Error(err, "unable to list AppSources")
+		return
+	}
+	type key struct{ namespace, conditionType string }
+	counts := map[key]int{}
+	for _, appSource := range appSources.Items {
+		for _, condition := range appSource.Status.Conditions {
+			counts[key{appSource.Namespace, condition.Type}]++
+		}
+	}
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(appSourceConditionsDesc, prometheus.GaugeValue, float64(count), k.namespace, k.conditionType)
+	}
+}
+
+// instrumentedApplicationClient records metrics for the ArgoCD Application API calls made by the controller
+type instrumentedApplicationClient struct {
+	applicationTypes.ApplicationServiceClient
+}
+
+func (c *instrumentedApplicationClient) Get(ctx context.Context, in *applicationTypes.ApplicationQuery, opts ...grpc.CallOption) (*v1alpha1.Application, error) {
+	start := time.Now()
+	app, err := c.ApplicationServiceClient.Get(ctx, in, opts...)
+	observeArgoCDCall("application", "Get", start, err)
+	return app, err
+}
+
+func (c *instrumentedApplicationClient) List(ctx context.Context, in *applicationTypes.ApplicationQuery, opts ...grpc.CallOption) (*v1alpha1.ApplicationList, error) {
+	start := time.Now()
+	apps, err := c.ApplicationServiceClient.List(ctx, in, opts...)
+	observeArgoCDCall("application", "List", start, err)
+	return apps, err
+}
+
+func (c *instrumentedApplicationClient) Create(ctx context.Context, in *applicationTypes.ApplicationCreateRequest, opts ...grpc.CallOption) (*v1alpha1.Application, error) {
+	start := time.Now()
+	app, err := c.ApplicationServiceClient.Create(ctx, in, opts...)
+	observeArgoCDCall("application", "Create", start, err)
+	return app, err
+}
+
+func (c *instrumentedApplicationClient) Update(ctx context.Context, in *applicationTypes.ApplicationUpdateRequest, opts ...grpc.CallOption) (*v1alpha1.Application, error) {
+	start := time.Now()
+	app, err := c.ApplicationServiceClient.Update(ctx, in, opts...)
+	observeArgoCDCall("application", "Update", start, err)
+	return app, err
+}
+
+func (c *instrumentedApplicationClient) Delete(ctx context.Context, in *applicationTypes.ApplicationDeleteRequest, opts ...grpc.CallOption) (*applicationTypes.ApplicationResponse, error) {
+	start := time.Now()
+	resp, err := c.ApplicationServiceClient.Delete(ctx, in, opts...)
+	observeArgoCDCall("application", "Delete", start, err)
+	return resp, err
+}
+
+// instrumentedProjectClient records metrics for the ArgoCD Project API calls made by the controller
+type instrumentedProjectClient struct {
+	projectTypes.ProjectServiceClient
+}
+
+func (c *instrumentedProjectClient) Get(ctx context.Context, in *projectTypes.ProjectQuery, opts ...grpc.CallOption) (*v1alpha1.AppProject, error) {
+	start := time.Now()
+	project, err := c.ProjectServiceClient.Get(ctx, in, opts...)
+	observeArgoCDCall("project", "Get", start, err)
+	return project, err
+}
+
+func (c *instrumentedProjectClient) Create(ctx context.Context, in *projectTypes.ProjectCreateRequest, opts ...grpc.CallOption) (*v1alpha1.AppProject, error) {
+	start := time.Now()
+	project, err := c.ProjectServiceClient.Create(ctx, in, opts...)
+	observeArgoCDCall("project", "Create", start, err)
+	return project, err
+}
+
+func (c *instrumentedProjectClient) Update(ctx context.Context, in *projectTypes.ProjectUpdateRequest, opts ...grpc.CallOption) (*v1alpha1.AppProject, error) {
+	start := time.Now()
+	project, err := c.ProjectServiceClient.Update(ctx, in, opts...)
+	observeArgoCDCall("project", "Update", start, err)
+	return project, err
+}
+
+func (c *instrumentedProjectClient) Delete(ctx context.Context, in *projectTypes.ProjectQuery, opts ...grpc.CallOption) (*projectTypes.EmptyResponse, error) {
+	start := time.Now()
+	resp, err := c.ProjectServiceClient.Delete(ctx, in, opts...)
+	observeArgoCDCall("project", "Delete", start, err)
+	return resp, err
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// notFoundApplicationClient fails every Get with NotFound
+type notFoundApplicationClient struct {
+	applicationTypes.ApplicationServiceClient
+}
+
+func (c *notFoundApplicationClient) Get(ctx context.Context, in *applicationTypes.ApplicationQuery, opts ...grpc.CallOption) (*argocd.Application, error) {
+	return nil, status.Error(codes.NotFound, "application not found")
+}
+
+var _ = Describe("AppSource metrics", func() {
+	It("counts ArgoCD API calls by status code", func() {
+		counter := argoCDRequests.WithLabelValues("application", "Get", "NotFound")
+		before := testutil.ToFloat64(counter)
+
+		client := &instrumentedApplicationClient{&notFoundApplicationClient{}}
+		name := "guestbook"
+		_, err := client.Get(context.Background(), &applicationTypes.ApplicationQuery{Name: &name})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+	})
+
+	It("reports AppSource conditions by namespace", func() {
+		scheme := runtime.NewScheme()
+		Expect(appsource.AddToScheme(scheme)).To(Succeed())
+		newAppSource := func(namespace, name string, conditionTypes ...string) *appsource.AppSource {
+			appSource := &appsource.AppSource{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+			for _, conditionType := range conditionTypes {
+				appSource.Status.Conditions = append(appSource.Status.Conditions, appsource.AppSourceCondition{Type: conditionType})
+			}
+			return appSource
+		}
+		reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			newAppSource("team-a", "api", appsource.ApplicationCreationSuccess),
+			newAppSource("team-a", "web", appsource.ApplicationCreationSuccess, appsource.ApplicationConflict),
+		).Build()
+
+		Expect(testutil.CollectAndCompare(&appSourceCollector{reader: reader}, strings.NewReader(`
+# HELP appsource_conditions Number of AppSources with a condition by namespace and condition type
+# TYPE appsource_conditions gauge
+appsource_conditions{namespace="team-a",type="ApplicationConflict"} 1
+appsource_conditions{namespace="team-a",type="ApplicationCreationSuccess"} 2
+`))).To(Succeed())
+	})
+
+	It("reports nothing until a manager is set up", func() {
+		Expect(testutil.CollectAndCount(&appSourceCollector{})).To(Equal(0))
+	})
+})
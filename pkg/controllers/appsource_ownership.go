@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// setApplicationOwner stamps the Application with the managed-by label and the namespace, name and UID of the AppSource
+func setApplicationOwner(app *v1alpha1.Application, appSource *appsource.AppSource) {
+	if app.Labels == nil {
+		app.Labels = map[string]string{}
+	}
+	app.Labels[appsource.ManagedByLabel] = appsource.ManagedByValue
+	if app.Annotations == nil {
+		app.Annotations = map[string]string{}
+	}
+	app.Annotations[appsource.OwnerNamespaceAnnotation] = appSource.Namespace
+	app.Annotations[appsource.OwnerNameAnnotation] = appSource.Name
+	app.Annotations[appsource.OwnerUIDAnnotation] = string(appSource.UID)
+}
+
+// applicationConflict returns an error if the Application is not owned by the AppSource. Applications
+// without an owner, such as those created before ownership tracking, are only adopted if they have the adopt annotation
+func (r *AppSourceReconciler) applicationConflict(appSource *appsource.AppSource, app *v1alpha1.Application) error {
+	uid, tracked := app.Annotations[appsource.OwnerUIDAnnotation]
+	if !tracked {
+		if app.Annotations[appsource.AdoptAnnotation] == "true" {
+			return nil
+		}
+		return fmt.Errorf("application %s already exists and is not managed by AppSource %s/%s, annotate it with %s: \"true\" to let the AppSource adopt it",
+			app.Name, appSource.Namespace, appSource.Name, appsource.AdoptAnnotation)
+	}
+	if uid != string(appSource.UID) {
+		return fmt.Errorf("application %s is owned by AppSource %s/%s (uid %s)", app.Name,
+			app.Annotations[appsource.OwnerNamespaceAnnotation], app.Annotations[appsource.OwnerNameAnnotation], uid)
+	}
+	return nil
+}
+
+// checkApplicationOwner records an ApplicationConflict condition and returns an error if the AppSource does not own the Application
+func (r *AppSourceReconciler) checkApplicationOwner(appSource *appsource.AppSource, app *v1alpha1.Application) error {
+	if err := r.applicationConflict(appSource, app); err != nil {
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationConflict,
+			Message:    err.Error(),
+			Status:     appsource.ConditionTrue,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+	appSource.RemoveCondition(appsource.ApplicationConflict)
+	return nil
+}
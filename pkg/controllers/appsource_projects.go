@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+const (
+	// projectDestinationsAnnotation lists the destinations the controller appended to an AppProject
+	projectDestinationsAnnotation = "appsource.argoproj.io/destinations"
+	// defaultProject is the ArgoCD built-in project, which is never adopted
+	defaultProject = "default"
+)
+
+// isManagedProject returns true if the AppProject was created by the AppSource controller
+func isManagedProject(appProject *v1alpha1.AppProject) bool {
+	return appProject.Labels[appsource.ManagedByLabel] == appsource.ManagedByValue
+}
+
+// isAdoptableProject returns true if the AppProject has no managed-by label and was either created by a
+// controller version predating the label, as its tracked destinations show, or opted in with the adopt annotation.
+// Projects labeled as managed by another tool and the default project are never adopted
+func isAdoptableProject(appProject *v1alpha1.AppProject) bool {
+	if _, ok := appProject.Labels[appsource.ManagedByLabel]; ok || appProject.Name == defaultProject {
+		return false
+	}
+	if _, ok := appProject.Annotations[projectDestinationsAnnotation]; ok {
+		return true
+	}
+	return appProject.Annotations[appsource.AdoptAnnotation] == "true"
+}
+
+// getTrackedDestinations returns the destinations the controller appended to the AppProject.
+// Projects created before destinations were tracked report every destination outside of the profile
+func getTrackedDestinations(appProject *v1alpha1.AppProject, profileSpec *v1alpha1.AppProjectSpec) ([]v1alpha1.ApplicationDestination, error) {
+	value, ok := appProject.Annotations[projectDestinationsAnnotation]
+	if !ok {
+		var destinations []v1alpha1.ApplicationDestination
+		for _, destination := range appProject.Spec.Destinations {
+			if !hasDestination(profileSpec.Destinations, destination) {
+				destinations = append(destinations, destination)
+			}
+		}
+		return destinations, nil
+	}
+	var destinations []v1alpha1.ApplicationDestination
+	if err := json.Unmarshal([]byte(value), &destinations); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on project %s: %v", projectDestinationsAnnotation, appProject.Name, err)
+	}
+	return destinations, nil
+}
+
+// setTrackedDestinations records the destinations the controller appended to the AppProject
+func setTrackedDestinations(appProject *v1alpha1.AppProject, destinations []v1alpha1.ApplicationDestination) error {
+	if destinations == nil {
+		destinations = []v1alpha1.ApplicationDestination{}
+	}
+	value, err := json.Marshal(destinations)
+	if err != nil {
+		return err
+	}
+	if appProject.Annotations == nil {
+		appProject.Annotations = map[string]string{}
+	}
+	appProject.Annotations[projectDestinationsAnnotation] = string(value)
+	return nil
+}
+
+// hasDestination returns true if destinations contains destination
+func hasDestination(destinations []v1alpha1.ApplicationDestination, destination v1alpha1.ApplicationDestination) bool {
+	for _, d := range destinations {
+		if d == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationCluster returns the server of the destination, or its cluster name if it has no server
+func destinationCluster(destination v1alpha1.ApplicationDestination) string {
+	if destination.Server == "" {
+		return destination.Name
+	}
+	return destination.Server
+}
+
+// desiredProject returns the AppProject matching the profile spec, keeping the destinations
+// the controller appended for AppSources
+func desiredProject(appProject *v1alpha1.AppProject, profileSpec *v1alpha1.AppProjectSpec) (*v1alpha1.AppProject, error) {
+	tracked, err := getTrackedDestinations(appProject, profileSpec)
+	if err != nil {
+		return nil, err
+	}
+	desired := appProject.DeepCopy()
+	desired.Spec = *profileSpec.DeepCopy()
+	// Role tokens are issued through ArgoCD and never part of the profile
+	for i := range desired.Spec.Roles {
+		for _, role := range appProject.Spec.Roles {
+			if role.Name == desired.Spec.Roles[i].Name {
+				desired.Spec.Roles[i].JWTTokens = role.JWTTokens
+			}
+		}
+	}
+	for _, destination := range tracked {
+		if !hasDestination(desired.Spec.Destinations, destination) {
+			desired.Spec.Destinations = append(desired.Spec.Destinations, destination)
+		}
+	}
+	if err := setTrackedDestinations(desired, tracked); err != nil {
+		return nil, err
+	}
+	return desired, nil
+}
+
+// syncProject updates an AppProject created by the controller when it drifted from its profile spec,
+// the applied changes are reported in an event on the AppSource. Adoptable unlabeled projects are adopted,
+// other unlabeled projects are left untouched and reported in a ProjectConflict condition
+func (r *AppSourceReconciler) syncProject(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate, appProject *v1alpha1.AppProject) error {
+	adopt := isAdoptableProject(appProject)
+	if _, labeled := appProject.Labels[appsource.ManagedByLabel]; !labeled && !adopt {
+		err := fmt.Errorf("project %s was not created by the AppSource controller, annotate it with %s: \"true\" to let the controller adopt it",
+			appProject.Name, appsource.AdoptAnnotation)
+		if appProject.Name == defaultProject {
+			err = fmt.Errorf("project %s is never adopted by the AppSource controller, label it as managed by another tool", appProject.Name)
+		}
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ProjectConflict,
+			Message:    err.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+	appSource.RemoveCondition(appsource.ProjectConflict)
+	if !isManagedProject(appProject) && !adopt {
+		// Project is managed by someone else
+		return nil
+	}
+	desired, err := desiredProject(appProject, proj.Spec)
+	if err != nil {
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationCreationError,
+			Message:    err.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+	if adopt {
+		if desired.Labels == nil {
+			desired.Labels = map[string]string{}
+		}
+		desired.Labels[appsource.ManagedByLabel] = appsource.ManagedByValue
+	}
+	if jsonEqual(appProject.Spec, desired.Spec) &&
+		equality.Semantic.DeepEqual(appProject.Labels, desired.Labels) &&
+		equality.Semantic.DeepEqual(appProject.Annotations, desired.Annotations) {
+		return nil
+	}
+
+	diff := projectSpecDiff(appProject.Spec, desired.Spec)
+	if _, err := backend.UpdateProject(ctx, desired); err != nil {
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationCreationError,
+			Message:    fmt.Sprintf("unable to update project %s to match its profile: %v", appProject.Name, err),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+	if adopt {
+		r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectAdopted", "Adopted project %s", appProject.Name)
+	}
+	if len(diff) > 0 {
+		r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectUpdated",
+			"Updated project %s to match its profile: %s", appProject.Name, strings.Join(diff, "; "))
+	}
+	return nil
+}
+
+// projectSpecDiff returns a human readable list of the AppProject spec fields which differ
+func projectSpecDiff(live, desired v1alpha1.AppProjectSpec) []string {
+	liveFields, desiredFields := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	liveJSON, _ := json.Marshal(live)
+	desiredJSON, _ := json.Marshal(desired)
+	_ = json.Unmarshal(liveJSON, &liveFields)
+	_ = json.Unmarshal(desiredJSON, &desiredFields)
+
+	keys := map[string]bool{}
+	for key := range liveFields {
+		keys[key] = true
+	}
+	for key := range desiredFields {
+		keys[key] = true
+	}
+	var diff []string
+	for key := range keys {
+		from, to := string(liveFields[key]), string(desiredFields[key])
+		if from == to {
+			continue
+		}
+		if from == "" {
+			from = "<none>"
+		}
+		if to == "" {
+			to = "<none>"
+		}
+		diff = append(diff, fmt.Sprintf("%s: %s -> %s", key, from, to))
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// pruneProject removes the destination appended for the AppSource namespace once no other AppSource
+// in the namespace remains, and deletes the AppProject once it no longer has any Applications besides appName,
+// the Application of the deleted AppSource. Only projects created by the controller are pruned
+func (r *AppSourceReconciler) pruneProject(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate, appName string, destination v1alpha1.ApplicationDestination) error {
+	projectName, err := proj.GetProjectName(appSource)
+	if err != nil {
+		return err
+	}
+	appProject, err := backend.GetProject(ctx, projectName)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !isManagedProject(appProject) {
+		return nil
+	}
+
+	apps, err := backend.ListApplications(ctx, projectName)
+	if err != nil {
+		return err
+	}
+	remaining := 0
+	for _, app := range apps {
+		if app.Name != appName {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		if err := backend.DeleteProject(ctx, projectName); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectDeleted", "Deleted project %s which has no applications left", projectName)
+		return nil
+	}
+
+	appSources := appsource.AppSourceList{}
+	if err := r.List(ctx, &appSources, client.InNamespace(appSource.Namespace)); err != nil {
+		return err
+	}
+	for _, other := range appSources.Items {
+		if other.Name != appSource.Name {
+			// Destination is still used by another AppSource of the namespace
+			return nil
+		}
+	}
+	tracked, err := getTrackedDestinations(appProject, proj.Spec)
+	if err != nil {
+		return err
+	}
+	if !hasDestination(tracked, destination) {
+		return nil
+	}
+	appProject.Spec.Destinations = removeDestination(appProject.Spec.Destinations, destination)
+	if err := setTrackedDestinations(appProject, removeDestination(tracked, destination)); err != nil {
+		return err
+	}
+	if _, err := backend.UpdateProject(ctx, appProject); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectDestinationRemoved",
+		"Removed destination %s/%s from project %s", destinationCluster(destination), destination.Namespace, projectName)
+	return nil
+}
+
+// removeDestination returns destinations without destination
+func removeDestination(destinations []v1alpha1.ApplicationDestination, destination v1alpha1.ApplicationDestination) []v1alpha1.ApplicationDestination {
+	result := []v1alpha1.ApplicationDestination{}
+	for _, d := range destinations {
+		if d != destination {
+			result = append(result, d)
+		}
+	}
+	return result
+}
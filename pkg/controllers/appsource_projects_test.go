@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource project sync", func() {
+	appended := argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: "my-project-us-west-2"}
+	profileSpec := &argocd.AppProjectSpec{
+		Description: "US West/East projects",
+		SourceRepos: []string{"https://github.com/argoproj/*"},
+	}
+
+	It("applies the profile spec while keeping appended destinations", func() {
+		live := &argocd.AppProject{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-project"},
+			Spec: argocd.AppProjectSpec{
+				SourceRepos:  []string{"*"},
+				Destinations: []argocd.ApplicationDestination{appended},
+			},
+		}
+		Expect(setTrackedDestinations(live, []argocd.ApplicationDestination{appended})).To(Succeed())
+
+		desired, err := desiredProject(live, profileSpec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desired.Spec.SourceRepos).To(ConsistOf("https://github.com/argoproj/*"))
+		Expect(desired.Spec.Destinations).To(ConsistOf(appended))
+		Expect(projectSpecDiff(live.Spec, desired.Spec)).To(Equal([]string{
+			`description: <none> -> "US West/East projects"`,
+			`sourceRepos: ["*"] -> ["https://github.com/argoproj/*"]`,
+		}))
+	})
+
+	It("drops destinations removed from the profile", func() {
+		removed := argocd.ApplicationDestination{Server: "*", Namespace: "*"}
+		live := &argocd.AppProject{Spec: argocd.AppProjectSpec{Destinations: []argocd.ApplicationDestination{removed, appended}}}
+		Expect(setTrackedDestinations(live, []argocd.ApplicationDestination{appended})).To(Succeed())
+
+		desired, err := desiredProject(live, profileSpec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desired.Spec.Destinations).To(ConsistOf(appended))
+	})
+
+	It("adopts destinations of projects created before tracking", func() {
+		live := &argocd.AppProject{Spec: argocd.AppProjectSpec{Destinations: []argocd.ApplicationDestination{appended}}}
+
+		desired, err := desiredProject(live, profileSpec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desired.Spec.Destinations).To(ConsistOf(appended))
+		tracked, err := getTrackedDestinations(desired, profileSpec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tracked).To(ConsistOf(appended))
+	})
+
+	It("removes a pruned destination", func() {
+		kept := argocd.ApplicationDestination{Server: "https://kubernetes.default.svc", Namespace: "other"}
+		Expect(removeDestination([]argocd.ApplicationDestination{kept, appended}, appended)).To(ConsistOf(kept))
+		Expect(removeDestination(nil, appended)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// mirrorApplicationStatus copies the sync and health status of the ArgoCD Application into the AppSource status
+// so users without access to ArgoCD can follow their deployment
+func mirrorApplicationStatus(appSource *appsource.AppSource, app *v1alpha1.Application) {
+	appSource.Status.Sync = string(app.Status.Sync.Status)
+	appSource.Status.Health = string(app.Status.Health.Status)
+	appSource.Status.Revision = app.Status.Sync.Revision
+	appSource.Status.ReconciledAt = app.Status.ReconciledAt
+
+	summary := appsource.AppSourceResourceSummary{Total: len(app.Status.Resources)}
+	for _, resource := range app.Status.Resources {
+		if resource.Status == v1alpha1.SyncStatusCodeOutOfSync {
+			summary.OutOfSync++
+		}
+		if resource.Health == nil {
+			continue
+		}
+		switch resource.Health.Status {
+		case "Healthy":
+			summary.Healthy++
+		case "Progressing":
+			summary.Progressing++
+		case "Degraded":
+			summary.Degraded++
+		case "Missing":
+			summary.Missing++
+		}
+	}
+	appSource.Status.Resources = &summary
+}
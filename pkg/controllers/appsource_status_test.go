@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource status", func() {
+	It("mirrors the Application sync and health status", func() {
+		reconciledAt := metav1.Now()
+		app := &argocd.Application{Status: argocd.ApplicationStatus{
+			Sync:         argocd.SyncStatus{Status: argocd.SyncStatusCodeOutOfSync, Revision: "abc123"},
+			Health:       argocd.HealthStatus{Status: "Progressing"},
+			ReconciledAt: &reconciledAt,
+			Resources: []argocd.ResourceStatus{
+				{Status: argocd.SyncStatusCodeSynced, Health: &argocd.HealthStatus{Status: "Healthy"}},
+				{Status: argocd.SyncStatusCodeOutOfSync, Health: &argocd.HealthStatus{Status: "Progressing"}},
+				{Status: argocd.SyncStatusCodeOutOfSync, Health: &argocd.HealthStatus{Status: "Missing"}},
+				{Status: argocd.SyncStatusCodeSynced, Health: &argocd.HealthStatus{Status: "Degraded"}},
+				{Status: argocd.SyncStatusCodeSynced},
+			},
+		}}
+		appSource := &appsource.AppSource{}
+
+		mirrorApplicationStatus(appSource, app)
+		Expect(appSource.Status.Sync).To(Equal("OutOfSync"))
+		Expect(appSource.Status.Health).To(Equal("Progressing"))
+		Expect(appSource.Status.Revision).To(Equal("abc123"))
+		Expect(appSource.Status.ReconciledAt).To(Equal(&reconciledAt))
+		Expect(appSource.Status.Resources).To(Equal(&appsource.AppSourceResourceSummary{
+			Total: 5, OutOfSync: 2, Healthy: 1, Progressing: 1, Degraded: 1, Missing: 1,
+		}))
+	})
+})
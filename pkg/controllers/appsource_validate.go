@@ -1,11 +1,14 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"strings"
 
-	applicationTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
-	projectTypes "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
@@ -13,15 +16,35 @@ import (
 
 //validateApplication Validates the existence of ArgoCD Application specified by the AppSource request.
 //If the Application does not exist, it is created
-func (r *AppSourceReconciler) validateApplication(ctx context.Context, appSource *appsource.AppSource, proj *ProjectTemplate) (err error) {
+func (r *AppSourceReconciler) validateApplication(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate) (err error) {
 
-	// Get the corresponding ArgoCD Application
-	_, found := r.Clients.Applications.Client.Get(ctx, &applicationTypes.ApplicationQuery{Name: &appSource.Name})
+	appName, err := applicationName(appSource, proj)
+	if err != nil {
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationInvalidSpecError,
+			Message:    err.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+
+	// Get the corresponding ArgoCD Application, only a missing Application is created
+	app, found := backend.GetApplication(ctx, appName)
+	if found != nil && !isNotFound(found) {
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationUnknownError,
+			Message:    found.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return found
+	}
 	if found != nil {
 
 		projectName, err := proj.GetProjectName(appSource)
 		if err != nil {
-			appSource.UpsertConditions(appsource.AppSourceCondition{
+			r.recordCondition(appSource, appsource.AppSourceCondition{
 				Type:       appsource.ApplicationInvalidSpecError,
 				Message:    err.Error(),
 				Status:     appsource.ConditionFalse,
@@ -30,13 +53,19 @@ func (r *AppSourceReconciler) validateApplication(ctx context.Context, appSource
 			return err
 		}
 
-		appSourceDestination := v1alpha1.ApplicationDestination{
-			Server:    r.ClusterHost,
-			Namespace: appSource.Namespace,
+		appSourceDestination, err := proj.GetDestination(appSource, r.ClusterHost)
+		if err != nil {
+			r.recordCondition(appSource, appsource.AppSourceCondition{
+				Type:       appsource.ApplicationInvalidSpecError,
+				Message:    err.Error(),
+				Status:     appsource.ConditionFalse,
+				ObservedAt: metav1.Now(),
+			})
+			return err
 		}
-		err = r.validateProjectDestinations(ctx, projectName, appSourceDestination)
+		err = r.validateProjectDestinations(ctx, backend, appSource, proj, projectName, appSourceDestination)
 		if err != nil {
-			appSource.UpsertConditions(appsource.AppSourceCondition{
+			r.recordCondition(appSource, appsource.AppSourceCondition{
 				Type:       appsource.ApplicationCreationError,
 				Message:    err.Error(),
 				Status:     appsource.ConditionFalse,
@@ -45,25 +74,25 @@ func (r *AppSourceReconciler) validateApplication(ctx context.Context, appSource
 			return err
 		}
 
+		application := v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      appName,
+				Namespace: r.ArgocdNS},
+			Spec: v1alpha1.ApplicationSpec{
+				Source:      desiredApplicationSource(appSource),
+				Destination: appSourceDestination,
+				Project:     projectName,
+			},
+		}
+		applyApplicationTemplate(&application, proj.ApplicationTemplate)
+		applySyncPolicy(&application, appSource, proj)
+		setApplicationOwner(&application, appSource)
+
 		// Send request to create Application
-		_, err = r.Clients.Applications.Client.Create(ctx,
-			&applicationTypes.ApplicationCreateRequest{
-				Application: v1alpha1.Application{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      appSource.Name,
-						Namespace: r.ArgocdNS},
-					Spec: v1alpha1.ApplicationSpec{
-						Source: v1alpha1.ApplicationSource{
-							RepoURL: appSource.Spec.RepoURL,
-							Path:    appSource.Spec.Path,
-						},
-						Destination: appSourceDestination,
-						Project:     projectName,
-					},
-				}})
+		app, err = backend.CreateApplication(ctx, &application)
 		if err != nil {
 			// Application could not be created
-			appSource.UpsertConditions(appsource.AppSourceCondition{
+			r.recordCondition(appSource, appsource.AppSourceCondition{
 				Type:       appsource.ApplicationCreationError,
 				Message:    err.Error(),
 				Status:     appsource.ConditionFalse,
@@ -78,19 +107,161 @@ func (r *AppSourceReconciler) validateApplication(ctx context.Context, appSource
 				Status:     appsource.ConditionTrue,
 				ObservedAt: metav1.Now(),
 			})
+			appSource.RemoveCondition(appsource.ApplicationCreationError)
+			r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ApplicationCreated", "Created application %s in project %s", appName, projectName)
+			appSource.Status.ApplicationName = appName
+			appSource.Status.ArgocdInstance = backend.Instance()
+			mirrorApplicationStatus(appSource, app)
 		}
+	} else {
+		// Never touch Applications owned by someone else
+		if err := r.checkApplicationOwner(appSource, app); err != nil {
+			return err
+		}
+		// Errors creating the Application or its project are resolved once it exists
+		appSource.RemoveCondition(appsource.ApplicationCreationError)
+		appSource.Status.ApplicationName = appName
+		appSource.Status.ArgocdInstance = backend.Instance()
+		mirrorApplicationStatus(appSource, app)
+		// Application exists, propagate any AppSource spec changes
+		return r.updateApplication(ctx, backend, appSource, proj, app)
 	}
 
 	return nil
 }
 
+//updateApplication Compares the live ArgoCD Application against the AppSource spec and the profile
+//Application template, and updates the Application if they have drifted apart. The destination and project
+//are only set at creation: moving a live Application to another cluster, namespace or project would make
+//ArgoCD deploy its resources anew while leaving the old ones behind
+func (r *AppSourceReconciler) updateApplication(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate, app *v1alpha1.Application) (err error) {
+	desired := app.DeepCopy()
+	desired.Spec.Source = desiredApplicationSource(appSource)
+	applyApplicationTemplate(desired, proj.ApplicationTemplate)
+	applySyncPolicy(desired, appSource, proj)
+	setApplicationOwner(desired, appSource)
+	if jsonEqual(app.Spec, desired.Spec) &&
+		equality.Semantic.DeepEqual(app.Labels, desired.Labels) &&
+		equality.Semantic.DeepEqual(app.Annotations, desired.Annotations) {
+		// Application is already up to date
+		appSource.RemoveCondition(appsource.ApplicationUpdateError)
+		return nil
+	}
+
+	if _, err = backend.UpdateApplication(ctx, desired); err != nil {
+		// Application could not be updated
+		r.recordCondition(appSource, appsource.AppSourceCondition{
+			Type:       appsource.ApplicationUpdateError,
+			Message:    err.Error(),
+			Status:     appsource.ConditionFalse,
+			ObservedAt: metav1.Now(),
+		})
+		return err
+	}
+	// Application was updated successfully
+	appSource.UpsertConditions(appsource.AppSourceCondition{
+		Type:       appsource.ApplicationUpdateSuccess,
+		Message:    appsource.ApplicationUpdateMsg,
+		Status:     appsource.ConditionTrue,
+		ObservedAt: metav1.Now(),
+	})
+	appSource.RemoveCondition(appsource.ApplicationUpdateError)
+	r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ApplicationUpdated", "Updated application %s", desired.Name)
+	return nil
+}
+
+//jsonEqual Compares ArgoCD specs by their JSON encoding. Reflection based comparisons panic on the
+//unexported fields of ArgoCD types such as ApplicationDestination
+func jsonEqual(a, b interface{}) bool {
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aJSON, bJSON)
+}
+
+//applicationName Returns the name of the ArgoCD Application of the AppSource. The name recorded in the status is
+//kept once the Application exists, so applicationNameTemplate changes do not rename existing Applications. proj may be nil
+func applicationName(appSource *appsource.AppSource, proj *ProjectTemplate) (string, error) {
+	if appSource.Status.ApplicationName != "" {
+		return appSource.Status.ApplicationName, nil
+	}
+	if proj == nil {
+		return appSource.Name, nil
+	}
+	return proj.GetApplicationName(appSource)
+}
+
+//desiredApplicationSource Returns the ArgoCD Application source described by the AppSource spec,
+//including any Helm, Kustomize, Directory or Plugin options
+func desiredApplicationSource(appSource *appsource.AppSource) v1alpha1.ApplicationSource {
+	return *appSource.Spec.ApplicationSource.DeepCopy()
+}
+
+//applyApplicationTemplate Merges the profile Application template into the Application,
+//template labels and annotations are added to the existing ones
+func applyApplicationTemplate(app *v1alpha1.Application, template *ApplicationTemplate) {
+	if template == nil {
+		return
+	}
+	for key, value := range template.Labels {
+		if app.Labels == nil {
+			app.Labels = map[string]string{}
+		}
+		app.Labels[key] = value
+	}
+	for key, value := range template.Annotations {
+		if app.Annotations == nil {
+			app.Annotations = map[string]string{}
+		}
+		app.Annotations[key] = value
+	}
+	app.Spec.SyncPolicy = template.SyncPolicy.DeepCopy()
+	if template.RevisionHistoryLimit != nil {
+		limit := *template.RevisionHistoryLimit
+		app.Spec.RevisionHistoryLimit = &limit
+	}
+	if template.IgnoreDifferences != nil {
+		app.Spec.IgnoreDifferences = make([]v1alpha1.ResourceIgnoreDifferences, len(template.IgnoreDifferences))
+		for i := range template.IgnoreDifferences {
+			template.IgnoreDifferences[i].DeepCopyInto(&app.Spec.IgnoreDifferences[i])
+		}
+	}
+}
+
+//applySyncPolicy Sets the Application sync policy to the profile default extended with the settings
+//requested by the AppSource, requested settings the profile does not allow are reported in a condition
+func applySyncPolicy(app *v1alpha1.Application, appSource *appsource.AppSource, proj *ProjectTemplate) {
+	if proj.ApplicationTemplate == nil && appSource.Spec.SyncPolicy == nil {
+		// Sync policy is neither managed by the profile nor requested by the AppSource
+		appSource.RemoveCondition(appsource.SyncPolicyClamped)
+		return
+	}
+	policy, denied := proj.resolveSyncPolicy(appSource)
+	app.Spec.SyncPolicy = policy
+	if len(denied) == 0 {
+		appSource.RemoveCondition(appsource.SyncPolicyClamped)
+		return
+	}
+	appSource.UpsertConditions(appsource.AppSourceCondition{
+		Type:       appsource.SyncPolicyClamped,
+		Message:    "sync policy settings not allowed by the project profile were ignored: " + strings.Join(denied, ", "),
+		Status:     appsource.ConditionTrue,
+		ObservedAt: metav1.Now(),
+	})
+}
+
 //validateProject Validates AppSource project against ArgoCD, empty project is created if it does not exist
-func (r *AppSourceReconciler) validateProject(ctx context.Context, appSource *appsource.AppSource, proj *ProjectTemplate) (err error) {
+func (r *AppSourceReconciler) validateProject(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate) (err error) {
 
 	// Get Project name from AppSource namespace
 	projectName, err := proj.GetProjectName(appSource)
 	if err != nil {
-		appSource.UpsertConditions(appsource.AppSourceCondition{
+		r.recordCondition(appSource, appsource.AppSourceCondition{
 			Type:       appsource.ApplicationCreationError,
 			Message:    err.Error(),
 			Status:     appsource.ConditionFalse,
@@ -99,35 +270,46 @@ func (r *AppSourceReconciler) validateProject(ctx context.Context, appSource *ap
 		return err
 	}
 
-	_, projectFound := r.Clients.Projects.Client.Get(ctx, &projectTypes.ProjectQuery{Name: projectName})
+	appProject, projectFound := backend.GetProject(ctx, projectName)
 	if projectFound != nil {
-		// Create ArgoCD Project
-		if _, err = r.Clients.Projects.Client.Create(ctx, &projectTypes.ProjectCreateRequest{
-			Project: &v1alpha1.AppProject{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: projectName,
-				},
-				Spec: *proj.Spec,
+		appProject = &v1alpha1.AppProject{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   projectName,
+				Labels: map[string]string{appsource.ManagedByLabel: appsource.ManagedByValue},
 			},
-			Upsert: false,
-		}); err != nil {
+			Spec: *proj.Spec,
+		}
+		if err = setTrackedDestinations(appProject, nil); err != nil {
+			r.recordCondition(appSource, appsource.AppSourceCondition{
+				Type:       appsource.ApplicationCreationError,
+				Message:    err.Error(),
+				Status:     appsource.ConditionFalse,
+				ObservedAt: metav1.Now(),
+			})
+			return err
+		}
+		// Create ArgoCD Project
+		if _, err = backend.CreateProject(ctx, appProject); err != nil {
 			// Project Creation failed
-			appSource.UpsertConditions(appsource.AppSourceCondition{
+			r.recordCondition(appSource, appsource.AppSourceCondition{
 				Type:       appsource.ApplicationCreationError,
 				Message:    err.Error(),
 				Status:     appsource.ConditionFalse,
 				ObservedAt: metav1.Now(),
 			})
+			return err
 		}
-		return err
+		r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectCreated", "Created project %s", projectName)
+		return nil
 	}
-	return err
+	// Keep projects created by the controller in sync with their profile
+	return r.syncProject(ctx, backend, appSource, proj, appProject)
 }
 
 //validateProjectDestinations Validates the existence of Application destination within AppProject Destinations list
 //Appends the destination in question if it is not present already
-func (r *AppSourceReconciler) validateProjectDestinations(ctx context.Context, projectName string, appSourceDestination v1alpha1.ApplicationDestination) (err error) {
-	appProject, err := r.Clients.Projects.Client.Get(ctx, &projectTypes.ProjectQuery{Name: projectName})
+func (r *AppSourceReconciler) validateProjectDestinations(ctx context.Context, backend ArgoCDBackend, appSource *appsource.AppSource, proj *ProjectTemplate, projectName string, appSourceDestination v1alpha1.ApplicationDestination) (err error) {
+	appProject, err := backend.GetProject(ctx, projectName)
 	if err != nil {
 		//Project should exist already
 		return err
@@ -140,6 +322,20 @@ func (r *AppSourceReconciler) validateProjectDestinations(ctx context.Context, p
 	}
 	//App destination does not exist already
 	appProject.Spec.Destinations = append(appProject.Spec.Destinations, appSourceDestination)
-	_, err = r.Clients.Projects.Client.Update(ctx, &projectTypes.ProjectUpdateRequest{Project: appProject})
-	return err
+	if isManagedProject(appProject) {
+		// Keep track of appended destinations so they survive profile syncs
+		tracked, err := getTrackedDestinations(appProject, proj.Spec)
+		if err != nil {
+			return err
+		}
+		if err = setTrackedDestinations(appProject, append(tracked, appSourceDestination)); err != nil {
+			return err
+		}
+	}
+	if _, err = backend.UpdateProject(ctx, appProject); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(appSource, v1.EventTypeNormal, "ProjectDestinationAdded",
+		"Added destination %s/%s to project %s", destinationCluster(appSourceDestination), appSourceDestination.Namespace, projectName)
+	return nil
 }
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("AppSource Application source", func() {
+	newAppSource := func(source argocd.ApplicationSource) *appsource.AppSource {
+		return &appsource.AppSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "my-project-us-west-2"},
+			Spec:       appsource.AppSourceSpec{ApplicationSource: source},
+		}
+	}
+
+	It("passes Helm sources through", func() {
+		appSource := newAppSource(argocd.ApplicationSource{
+			RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+			Path:           "helm-guestbook",
+			TargetRevision: "v1.0.0",
+			Helm: &argocd.ApplicationSourceHelm{
+				ValueFiles:  []string{"values-production.yaml"},
+				Values:      "replicaCount: 2\n",
+				ReleaseName: "guestbook",
+				Parameters:  []argocd.HelmParameter{{Name: "image.tag", Value: "v2"}},
+			},
+		})
+
+		source := desiredApplicationSource(appSource)
+		Expect(source).To(Equal(appSource.Spec.ApplicationSource))
+		Expect(source.Helm.ValueFiles).To(ConsistOf("values-production.yaml"))
+		Expect(source.Helm.Values).To(Equal("replicaCount: 2\n"))
+	})
+
+	It("passes Kustomize sources through", func() {
+		appSource := newAppSource(argocd.ApplicationSource{
+			RepoURL: "https://github.com/argoproj/argocd-example-apps",
+			Path:    "kustomize-guestbook",
+			Kustomize: &argocd.ApplicationSourceKustomize{
+				NamePrefix:   "dev-",
+				Images:       argocd.KustomizeImages{"gcr.io/heptio-images/ks-guestbook-demo:0.2"},
+				CommonLabels: map[string]string{"team": "guestbook"},
+			},
+		})
+
+		source := desiredApplicationSource(appSource)
+		Expect(source).To(Equal(appSource.Spec.ApplicationSource))
+		Expect(source.Kustomize.NamePrefix).To(Equal("dev-"))
+		Expect(source.Kustomize.CommonLabels).To(HaveKeyWithValue("team", "guestbook"))
+	})
+
+	It("detects changed Helm values as drift", func() {
+		appSource := newAppSource(argocd.ApplicationSource{
+			RepoURL: "https://github.com/argoproj/argocd-example-apps",
+			Path:    "helm-guestbook",
+			Helm:    &argocd.ApplicationSourceHelm{Values: "replicaCount: 1\n"},
+		})
+		live := desiredApplicationSource(appSource)
+		Expect(live.Equals(desiredApplicationSource(appSource))).To(BeTrue())
+
+		appSource.Spec.Helm.Values = "replicaCount: 3\n"
+		Expect(live.Equals(desiredApplicationSource(appSource))).To(BeFalse())
+		Expect(live.Helm.Values).To(Equal("replicaCount: 1\n"))
+	})
+
+	It("only permits repositories allowed by the project profile", func() {
+		config, err := parseProfiles(`
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    spec:
+      sourceRepos:
+      - 'https://github.com/argoproj/*'
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		appSource := newAppSource(argocd.ApplicationSource{
+			RepoURL: "https://github.com/argoproj/argocd-example-apps",
+			Helm:    &argocd.ApplicationSourceHelm{Values: "replicaCount: 1\n"},
+		})
+		_, err = config.ValidateAppSource(appSource, namespaceNamed(appSource.Namespace))
+		Expect(err).NotTo(HaveOccurred())
+
+		appSource.Spec.RepoURL = "https://github.com/someone-else/apps"
+		_, err = config.ValidateAppSource(appSource, namespaceNamed(appSource.Namespace))
+		Expect(err).To(MatchError(ContainSubstring("not permitted in project my-project")))
+	})
+})
+
+var _ = Describe("AppSource Application template", func() {
+	It("compares Application specs holding unexported ArgoCD fields", func() {
+		spec := argocd.ApplicationSpec{Destination: argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: "guestbook"}}
+		desired := spec.DeepCopy()
+		Expect(jsonEqual(spec, *desired)).To(BeTrue())
+		desired.Destination.Namespace = "payments"
+		Expect(jsonEqual(spec, *desired)).To(BeFalse())
+	})
+
+	It("merges the profile template into the Application", func() {
+		limit := int64(3)
+		template := &ApplicationTemplate{
+			Labels:      map[string]string{"env": "dev"},
+			Annotations: map[string]string{"notifications.argoproj.io/subscribe": "slack"},
+			SyncPolicy: &argocd.SyncPolicy{
+				Automated:   &argocd.SyncPolicyAutomated{Prune: true, SelfHeal: true},
+				SyncOptions: argocd.SyncOptions{"CreateNamespace=true"},
+			},
+			RevisionHistoryLimit: &limit,
+			IgnoreDifferences: []argocd.ResourceIgnoreDifferences{
+				{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas"}},
+			},
+		}
+		app := argocd.Application{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"team": "guestbook"}}}
+
+		applyApplicationTemplate(&app, template)
+		Expect(app.Labels).To(Equal(map[string]string{"team": "guestbook", "env": "dev"}))
+		Expect(app.Annotations).To(HaveKeyWithValue("notifications.argoproj.io/subscribe", "slack"))
+		Expect(app.Spec.SyncPolicy.Automated.SelfHeal).To(BeTrue())
+		Expect(app.Spec.SyncPolicy.SyncOptions).To(ConsistOf("CreateNamespace=true"))
+		Expect(*app.Spec.RevisionHistoryLimit).To(Equal(int64(3)))
+		Expect(app.Spec.IgnoreDifferences).To(Equal(template.IgnoreDifferences))
+
+		app.Spec.SyncPolicy.Automated.Prune = false
+		Expect(template.SyncPolicy.Automated.Prune).To(BeTrue())
+	})
+
+	It("enforces manual sync when the template has no sync policy", func() {
+		app := argocd.Application{Spec: argocd.ApplicationSpec{
+			SyncPolicy: &argocd.SyncPolicy{Automated: &argocd.SyncPolicyAutomated{}},
+		}}
+		applyApplicationTemplate(&app, &ApplicationTemplate{})
+		Expect(app.Spec.SyncPolicy).To(BeNil())
+	})
+})
+
+var _ = Describe("AppSource Application ownership", func() {
+	recorder := record.NewFakeRecorder(10)
+	r := &AppSourceReconciler{ClusterHost: appsource.ClusterServerName, Recorder: recorder}
+	appSource := &appsource.AppSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "my-project-us-west-2", UID: "1234"},
+	}
+	destination := argocd.ApplicationDestination{Server: appsource.ClusterServerName, Namespace: "my-project-us-west-2"}
+
+	It("owns the Applications it stamps", func() {
+		app := &argocd.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook"}}
+		setApplicationOwner(app, appSource)
+		Expect(app.Labels).To(HaveKeyWithValue(appsource.ManagedByLabel, appsource.ManagedByValue))
+		Expect(app.Annotations).To(HaveKeyWithValue(appsource.OwnerUIDAnnotation, "1234"))
+		Expect(r.applicationConflict(appSource, app)).To(Succeed())
+	})
+
+	It("reports Applications owned by another AppSource", func() {
+		other := appSource.DeepCopy()
+		other.Namespace, other.UID = "other-us-west-2", "5678"
+		app := &argocd.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook"}}
+		setApplicationOwner(app, other)
+
+		Expect(r.checkApplicationOwner(appSource, app)).To(MatchError(ContainSubstring("owned by AppSource other-us-west-2/guestbook")))
+		Expect(appSource.Status.Conditions).To(HaveLen(1))
+		Expect(appSource.Status.Conditions[0].Type).To(Equal(appsource.ApplicationConflict))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning ApplicationConflict")))
+	})
+
+	It("reports untracked Applications, even if they target the AppSource namespace", func() {
+		app := &argocd.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook"},
+			Spec:       argocd.ApplicationSpec{Destination: destination},
+		}
+		Expect(r.applicationConflict(appSource, app)).To(MatchError(ContainSubstring("not managed by AppSource")))
+
+		app.Spec.Destination.Namespace = "guestbook"
+		Expect(r.applicationConflict(appSource, app)).To(MatchError(ContainSubstring("not managed by AppSource")))
+	})
+
+	It("adopts untracked Applications with the adopt annotation", func() {
+		app := &argocd.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Annotations: map[string]string{appsource.AdoptAnnotation: "true"}},
+			Spec:       argocd.ApplicationSpec{Destination: destination},
+		}
+		Expect(r.applicationConflict(appSource, app)).To(Succeed())
+
+		// Applications owned by another AppSource are never adopted
+		other := appSource.DeepCopy()
+		other.UID = "5678"
+		setApplicationOwner(app, other)
+		Expect(r.applicationConflict(appSource, app)).To(MatchError(ContainSubstring("owned by AppSource")))
+	})
+})
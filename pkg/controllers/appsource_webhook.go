@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+const (
+	//AppSource validating webhook path
+	appSourceWebhookPath = "/validate-argoproj-io-v1alpha1-appsource"
+	//AppSource configmap validating webhook path
+	configMapWebhookPath = "/validate-v1-configmap"
+)
+
+//+kubebuilder:webhook:path=/validate-argoproj-io-v1alpha1-appsource,mutating=false,failurePolicy=fail,sideEffects=None,groups=argoproj.io,resources=appsources,verbs=create;update,versions=v1alpha1,name=vappsource.argoproj.io,admissionReviewVersions={v1,v1beta1}
+
+// AppSourceValidator rejects AppSources which do not match any project profile
+// or whose repository is not permitted by the matching profile
+type AppSourceValidator struct {
+	Config *ConfigStore
+	// Reader used to get the AppSource namespace, which profiles are matched against
+	Reader  client.Reader
+	decoder *admission.Decoder
+}
+
+// Handle validates AppSource create and update requests
+func (v *AppSourceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	appSource := appsource.AppSource{}
+	if err := v.decoder.Decode(req, &appSource); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !appSource.DeletionTimestamp.IsZero() {
+		// Never block finalizer removal
+		return admission.Allowed("")
+	}
+
+	config, err := v.Config.Load(ctx)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	namespace := &v1.Namespace{}
+	if err := v.Reader.Get(ctx, types.NamespacedName{Name: appSource.Namespace}, namespace); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if _, err := config.ValidateAppSource(&appSource, namespace); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the admission decoder
+func (v *AppSourceValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-v1-configmap,mutating=false,failurePolicy=fail,sideEffects=None,groups="",resources=configmaps,verbs=create;update,versions=v1,name=vconfigmap.appsource.argoproj.io,admissionReviewVersions={v1,v1beta1}
+
+// ConfigMapValidator rejects edits to the AppSource configmap which the controller could not load
+type ConfigMapValidator struct {
+	Config  *ConfigStore
+	decoder *admission.Decoder
+}
+
+// Handle validates AppSource configmap create and update requests, other configmaps are allowed
+func (v *ConfigMapValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	configMap := v1.ConfigMap{}
+	if err := v.decoder.Decode(req, &configMap); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !v.Config.IsConfigMap(&configMap) {
+		return admission.Allowed("")
+	}
+	if _, err := ParseAppSourceConfig(&configMap, v.Config.DefaultNamespace); err != nil {
+		return admission.Denied("invalid " + configMap.Name + ": " + err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the admission decoder
+func (v *ConfigMapValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// SetupWebhookWithManager registers the AppSource and configmap validating webhooks with the Manager.
+func (r *AppSourceReconciler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if err := r.setupConfig(mgr); err != nil {
+		return err
+	}
+	mgr.GetWebhookServer().Register(appSourceWebhookPath, &webhook.Admission{Handler: &AppSourceValidator{Config: r.Config, Reader: mgr.GetClient()}})
+	mgr.GetWebhookServer().Register(configMapWebhookPath, &webhook.Admission{Handler: &ConfigMapValidator{Config: r.Config}})
+	return nil
+}
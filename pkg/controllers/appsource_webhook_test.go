@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	argocd "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+// admissionRequest returns an admission request for operation on obj
+func admissionRequest(operation admissionv1.Operation, obj client.Object) admission.Request {
+	raw, err := json.Marshal(obj)
+	Expect(err).NotTo(HaveOccurred())
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+// newAdmissionDecoder returns a decoder of the core and AppSource types
+func newAdmissionDecoder() *admission.Decoder {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(appsource.AddToScheme(scheme)).To(Succeed())
+	decoder, err := admission.NewDecoder(scheme)
+	Expect(err).NotTo(HaveOccurred())
+	return decoder
+}
+
+var _ = Describe("AppSource webhook", func() {
+	const namespace = "my-project-us-west-2"
+	var (
+		ctx       context.Context
+		validator *AppSourceValidator
+	)
+	configMapKey := types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		k8s := fake.NewClientBuilder().WithObjects(
+			&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: configMapKey.Namespace, Name: configMapKey.Name},
+				Data: map[string]string{
+					"argocd.address": "argocd-server.argocd.svc:443",
+					"project.profiles": `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    spec:
+      sourceRepos:
+      - 'https://github.com/argoproj/*'
+`,
+				},
+			},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unmatched"}},
+		).Build()
+		validator = &AppSourceValidator{Config: NewConfigStore(k8s, configMapKey, appsource.ArgocdNamespace), Reader: k8s}
+		Expect(validator.InjectDecoder(newAdmissionDecoder())).To(Succeed())
+	})
+
+	newAppSource := func(namespace, repoURL string) *appsource.AppSource {
+		return &appsource.AppSource{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "guestbook"},
+			Spec: appsource.AppSourceSpec{ApplicationSource: argocd.ApplicationSource{
+				RepoURL: repoURL,
+				Path:    "guestbook",
+			}},
+		}
+	}
+
+	It("allows AppSources permitted by their profile", func() {
+		appSource := newAppSource(namespace, "https://github.com/argoproj/argocd-example-apps")
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Create, appSource)).Allowed).To(BeTrue())
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Update, appSource)).Allowed).To(BeTrue())
+	})
+
+	It("denies AppSources whose repository is not permitted by their profile", func() {
+		response := validator.Handle(ctx, admissionRequest(admissionv1.Create, newAppSource(namespace, "https://github.com/example/apps")))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+	})
+
+	It("denies AppSources whose namespace matches no profile", func() {
+		response := validator.Handle(ctx, admissionRequest(admissionv1.Create, newAppSource("unmatched", "https://github.com/argoproj/argocd-example-apps")))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+	})
+
+	It("never blocks deletions and finalizer removal", func() {
+		appSource := newAppSource(namespace, "https://github.com/example/apps")
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Delete, appSource)).Allowed).To(BeTrue())
+		now := metav1.Now()
+		appSource.DeletionTimestamp = &now
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Update, appSource)).Allowed).To(BeTrue())
+	})
+
+	It("reports errors when the configmap cannot be loaded", func() {
+		validator.Config = NewConfigStore(fake.NewClientBuilder().Build(), configMapKey, appsource.ArgocdNamespace)
+		response := validator.Handle(ctx, admissionRequest(admissionv1.Create, newAppSource(namespace, "https://github.com/argoproj/argocd-example-apps")))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(http.StatusInternalServerError))
+	})
+})
+
+var _ = Describe("AppSource configmap webhook", func() {
+	var (
+		ctx       context.Context
+		validator *ConfigMapValidator
+	)
+	configMapKey := types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		validator = &ConfigMapValidator{Config: NewConfigStore(fake.NewClientBuilder().Build(), configMapKey, appsource.ArgocdNamespace)}
+		Expect(validator.InjectDecoder(newAdmissionDecoder())).To(Succeed())
+	})
+
+	newConfigMap := func(key types.NamespacedName, profiles string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data: map[string]string{
+				"argocd.address":   "argocd-server.argocd.svc:443",
+				"project.profiles": profiles,
+			},
+		}
+	}
+	const validProfiles = `
+- my-project:
+    namePattern: (?P<project>.*)-us-(west|east)-(\d.*)
+    spec:
+      sourceRepos: ['*']
+`
+
+	It("allows valid AppSource configmaps", func() {
+		configMap := newConfigMap(configMapKey, validProfiles)
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Create, configMap)).Allowed).To(BeTrue())
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Update, configMap)).Allowed).To(BeTrue())
+	})
+
+	It("denies AppSource configmaps the controller could not load", func() {
+		response := validator.Handle(ctx, admissionRequest(admissionv1.Update, newConfigMap(configMapKey, "- my-project:\n    namePattern: (\n")))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+		Expect(string(response.Result.Reason)).To(HavePrefix("invalid " + DefaultConfigMapName + ": "))
+	})
+
+	It("allows other configmaps and deletions", func() {
+		invalid := "- my-project:\n    namePattern: (\n"
+		other := newConfigMap(types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: "argocd-cm"}, invalid)
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Update, other)).Allowed).To(BeTrue())
+		elsewhere := newConfigMap(types.NamespacedName{Namespace: "default", Name: DefaultConfigMapName}, invalid)
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Update, elsewhere)).Allowed).To(BeTrue())
+		Expect(validator.Handle(ctx, admissionRequest(admissionv1.Delete, newConfigMap(configMapKey, invalid))).Allowed).To(BeTrue())
+	})
+})
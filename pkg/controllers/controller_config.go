@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+const (
+	// DefaultConfigMapName is the default name of the AppSource configmap
+	DefaultConfigMapName = "argocd-appsource-cm"
+	// configFileEnv names the environment variable holding the controller config file path
+	configFileEnv = "APPSOURCE_CONFIG"
+	// envPrefix prefixes the environment variables overriding controller settings,
+	// e.g. APPSOURCE_ARGOCD_NAMESPACE overrides --argocd-namespace
+	envPrefix = "APPSOURCE_"
+)
+
+// ControllerConfig holds the settings of the controller process. Settings are read from the defaults,
+// the optional config file, APPSOURCE_* environment variables and flags, in increasing precedence
+type ControllerConfig struct {
+	// Namespace of the ArgoCD instance
+	ArgocdNamespace string `json:"argocdNamespace,omitempty"`
+	// Server address of the cluster the controller runs in, the destination of Applications whose profile has none
+	ClusterHost string `json:"clusterHost,omitempty"`
+	// Name of the AppSource configmap
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// Namespace of the AppSource configmap, defaults to the ArgoCD namespace
+	ConfigMapNamespace string `json:"configMapNamespace,omitempty"`
+	// Address the metrics endpoint binds to, 0 disables it
+	MetricsBindAddress string `json:"metricsBindAddress,omitempty"`
+	// Address the health probe endpoint binds to, 0 disables it
+	HealthProbeBindAddress string `json:"healthProbeBindAddress,omitempty"`
+	// Enables leader election, so only one controller is active at a time
+	LeaderElection bool `json:"leaderElection,omitempty"`
+	// Name of the leader election lock
+	LeaderElectionID string `json:"leaderElectionID,omitempty"`
+	// Namespace of the leader election lock, defaults to the namespace the controller runs in
+	LeaderElectionNamespace string `json:"leaderElectionNamespace,omitempty"`
+	// Enables the AppSource validating admission webhook
+	EnableWebhooks bool `json:"enableWebhooks,omitempty"`
+	// Interval at which ArgoCD Application status is mirrored into AppSources
+	StatusRefreshInterval metav1.Duration `json:"statusRefreshInterval,omitempty"`
+	// Interval at which Applications deleted in cascade-foreground mode are checked
+	DeletionPollInterval metav1.Duration `json:"deletionPollInterval,omitempty"`
+	// Time to wait for a cascade-foreground deletion before reporting an error, zero waits forever
+	DeletionTimeout metav1.Duration `json:"deletionTimeout,omitempty"`
+}
+
+// DefaultControllerConfig returns the default controller settings
+func DefaultControllerConfig() *ControllerConfig {
+	return &ControllerConfig{
+		ArgocdNamespace:        appsource.ArgocdNamespace,
+		ClusterHost:            appsource.ClusterServerName,
+		ConfigMapName:          DefaultConfigMapName,
+		MetricsBindAddress:     ":8080",
+		HealthProbeBindAddress: ":8081",
+		LeaderElectionID:       "02ff6e16.io",
+		StatusRefreshInterval:  metav1.Duration{Duration: 3 * time.Minute},
+		DeletionPollInterval:   metav1.Duration{Duration: 10 * time.Second},
+		DeletionTimeout:        metav1.Duration{Duration: 10 * time.Minute},
+	}
+}
+
+// bindFlags registers a flag for every setting on fs
+func (c *ControllerConfig) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ArgocdNamespace, "argocd-namespace", c.ArgocdNamespace, "The namespace of the ArgoCD instance.")
+	fs.StringVar(&c.ClusterHost, "cluster-host", c.ClusterHost,
+		"The server address of the cluster the controller runs in, used as the destination of Applications whose profile has none.")
+	fs.StringVar(&c.ConfigMapName, "configmap-name", c.ConfigMapName, "The name of the AppSource configmap.")
+	fs.StringVar(&c.ConfigMapNamespace, "configmap-namespace", c.ConfigMapNamespace,
+		"The namespace of the AppSource configmap, defaults to the ArgoCD namespace.")
+	fs.StringVar(&c.MetricsBindAddress, "metrics-bind-address", c.MetricsBindAddress, "The address the metric endpoint binds to.")
+	fs.StringVar(&c.HealthProbeBindAddress, "health-probe-bind-address", c.HealthProbeBindAddress, "The address the probe endpoint binds to.")
+	fs.BoolVar(&c.LeaderElection, "leader-elect", c.LeaderElection,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	fs.StringVar(&c.LeaderElectionID, "leader-election-id", c.LeaderElectionID, "The name of the leader election lock.")
+	fs.StringVar(&c.LeaderElectionNamespace, "leader-election-namespace", c.LeaderElectionNamespace,
+		"The namespace of the leader election lock, defaults to the namespace the controller runs in.")
+	fs.BoolVar(&c.EnableWebhooks, "enable-webhooks", c.EnableWebhooks,
+		"Enable the AppSource validating admission webhook served on port 9443.")
+	fs.DurationVar(&c.StatusRefreshInterval.Duration, "status-refresh-interval", c.StatusRefreshInterval.Duration,
+		"The interval at which ArgoCD Application status is mirrored into AppSources.")
+	fs.DurationVar(&c.DeletionPollInterval.Duration, "deletion-poll-interval", c.DeletionPollInterval.Duration,
+		"The interval at which Applications deleted in cascade-foreground mode are checked for completion.")
+	fs.DurationVar(&c.DeletionTimeout.Duration, "deletion-timeout", c.DeletionTimeout.Duration,
+		"How long to wait for a cascade-foreground Application deletion before reporting an error, zero waits forever.")
+}
+
+// LoadControllerConfig parses args with fs, which gets the controller flags and a --config flag registered,
+// and returns the validated controller config. The config file is read from --config or APPSOURCE_CONFIG,
+// and every flag may be set from an environment variable named after it, e.g. APPSOURCE_ARGOCD_NAMESPACE
+func LoadControllerConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (*ControllerConfig, error) {
+	flags := DefaultControllerConfig()
+	flags.bindFlags(fs)
+	configFile := fs.String("config", getenv(configFileEnv), "Path of the controller config file.")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := DefaultControllerConfig()
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read controller config: %v", err)
+		}
+		if err := unmarshalStrict(data, config); err != nil {
+			return nil, fmt.Errorf("invalid controller config %s: %v", *configFile, err)
+		}
+	}
+	// Environment variables and flags are applied through a flag set bound to config, so they are parsed alike
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	config.bindFlags(overrides)
+	var err error
+	overrides.VisitAll(func(f *flag.Flag) {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value := getenv(env); value != "" && err == nil {
+			if setErr := overrides.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %v", env, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if overrides.Lookup(f.Name) != nil && err == nil {
+			err = overrides.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ConfigMapNamespace == "" {
+		config.ConfigMapNamespace = config.ArgocdNamespace
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// unmarshalStrict decodes the YAML or JSON data into config, rejecting unknown settings
+func unmarshalStrict(data []byte, config *ControllerConfig) error {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// Validate checks every setting, reporting all invalid settings at once
+func (c *ControllerConfig) Validate() error {
+	var errs field.ErrorList
+	for _, namespace := range []struct{ path, value string }{
+		{"argocdNamespace", c.ArgocdNamespace},
+		{"configMapNamespace", c.ConfigMapNamespace},
+		{"leaderElectionNamespace", c.LeaderElectionNamespace},
+	} {
+		if namespace.value == "" && namespace.path == "leaderElectionNamespace" {
+			// Defaults to the namespace the controller runs in
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Label(namespace.value) {
+			errs = append(errs, field.Invalid(field.NewPath(namespace.path), namespace.value, msg))
+		}
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(c.ConfigMapName) {
+		errs = append(errs, field.Invalid(field.NewPath("configMapName"), c.ConfigMapName, msg))
+	}
+	if host, err := url.Parse(c.ClusterHost); err != nil || (host.Scheme != "https" && host.Scheme != "http") || host.Host == "" {
+		errs = append(errs, field.Invalid(field.NewPath("clusterHost"), c.ClusterHost, "must be an http or https URL"))
+	}
+	for _, address := range []struct{ path, value string }{
+		{"metricsBindAddress", c.MetricsBindAddress},
+		{"healthProbeBindAddress", c.HealthProbeBindAddress},
+	} {
+		if address.value == "0" {
+			// Endpoint is disabled
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address.value); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath(address.path), address.value, "must be host:port, :port or 0 to disable"))
+		}
+	}
+	if c.LeaderElection && c.LeaderElectionID == "" {
+		errs = append(errs, field.Required(field.NewPath("leaderElectionID"), "required when leader election is enabled"))
+	}
+	if c.StatusRefreshInterval.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("statusRefreshInterval"), c.StatusRefreshInterval.String(), "must not be negative"))
+	}
+	if c.DeletionPollInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(field.NewPath("deletionPollInterval"), c.DeletionPollInterval.String(), "must be positive"))
+	}
+	if c.DeletionTimeout.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("deletionTimeout"), c.DeletionTimeout.String(), "must not be negative"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid controller config: %v", errs.ToAggregate())
+	}
+	return nil
+}
+
+// ConfigMap returns the location of the AppSource configmap
+func (c *ControllerConfig) ConfigMap() types.NamespacedName {
+	return types.NamespacedName{Namespace: c.ConfigMapNamespace, Name: c.ConfigMapName}
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsource "github.com/argoproj-labs/argocd-app-source/pkg/api/v1alpha1"
+)
+
+var _ = Describe("Controller config", func() {
+	var (
+		env map[string]string
+		dir string
+	)
+	load := func(args ...string) (*ControllerConfig, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		return LoadControllerConfig(fs, args, func(key string) string { return env[key] })
+	}
+	writeFile := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		env = map[string]string{}
+		var err error
+		dir, err = ioutil.TempDir("", "appsource-config")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("defaults the configmap to the ArgoCD namespace", func() {
+		config, err := load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ClusterHost).To(Equal(appsource.ClusterServerName))
+		Expect(config.ConfigMap()).To(Equal(types.NamespacedName{Namespace: appsource.ArgocdNamespace, Name: DefaultConfigMapName}))
+
+		config, err = load("--argocd-namespace", "gitops")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ConfigMap()).To(Equal(types.NamespacedName{Namespace: "gitops", Name: DefaultConfigMapName}))
+	})
+
+	It("applies the config file, then environment variables, then flags", func() {
+		env["APPSOURCE_CONFIG"] = writeFile(`
+argocdNamespace: gitops
+clusterHost: https://hub.example.com
+leaderElection: true
+deletionTimeout: 5m
+`)
+		env["APPSOURCE_CLUSTER_HOST"] = "https://env.example.com"
+		env["APPSOURCE_METRICS_BIND_ADDRESS"] = ":9090"
+		config, err := load("--metrics-bind-address", ":9091")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ArgocdNamespace).To(Equal("gitops"))
+		Expect(config.ClusterHost).To(Equal("https://env.example.com"))
+		Expect(config.MetricsBindAddress).To(Equal(":9091"))
+		Expect(config.LeaderElection).To(BeTrue())
+		Expect(config.DeletionTimeout.Duration).To(Equal(5 * time.Minute))
+		Expect(config.DeletionPollInterval.Duration).To(Equal(10 * time.Second))
+	})
+
+	It("rejects unknown settings in the config file", func() {
+		_, err := load("--config", writeFile("argocdNamespaces: gitops\n"))
+		Expect(err).To(MatchError(ContainSubstring("unknown field")))
+	})
+
+	It("reports every invalid setting", func() {
+		_, err := load("--cluster-host", "kubernetes.default.svc", "--configmap-namespace", "Argo_CD", "--health-probe-bind-address", "8081")
+		Expect(err).To(MatchError(ContainSubstring("clusterHost")))
+		Expect(err).To(MatchError(ContainSubstring("configMapNamespace")))
+		Expect(err).To(MatchError(ContainSubstring("healthProbeBindAddress")))
+
+		env["APPSOURCE_LEADER_ELECT"] = "maybe"
+		_, err = load()
+		Expect(err).To(MatchError(ContainSubstring("APPSOURCE_LEADER_ELECT")))
+	})
+})
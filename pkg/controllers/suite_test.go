@@ -82,6 +82,7 @@ var _ = BeforeSuite(func() {
 		Scheme:      k8sManager.GetScheme(),
 		ArgocdNS:    appsource.ArgocdNamespace,
 		ClusterHost: appsource.ClusterServerName,
+		ArgoCD:      &ClientManager{NewClients: newFakeArgoCD().newClients},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 